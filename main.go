@@ -2,8 +2,11 @@ package main
 
 import (
   "fmt"
+  "math"
+  "gonum.org/v1/gonum/mat"
   "./urdf"
   "./rigid"
+  "./sim"
 )
 
 func main() {
@@ -86,4 +89,50 @@ func main() {
   qq := base.GetCopy()
   fmt.Println(qq)
   
+  // mass matrix vs finite differences of inverse dynamics
+  lst := ee.Predecessors()
+  base.UpdateState(qs)
+  mm := base.MassMatrix(lst)
+  h := 1E-3
+  diff := 0.0
+  for j, jnt := range lst {
+    qs[jnt.Src.Name][2] = h
+    base.UpdateState(qs)
+    base.UpdateDyn(9.81)
+    tp := rigid.ReadTorques(lst)
+    qs[jnt.Src.Name][2] = -h
+    base.UpdateState(qs)
+    base.UpdateDyn(9.81)
+    tm := rigid.ReadTorques(lst)
+    qs[jnt.Src.Name][2] = 0
+    for i := range lst {
+      diff = math.Max(diff, math.Abs((tp.At(i,0)-tm.At(i,0))/(2*h) - mm.At(i,j)))
+    }
+  }
+  println()
+  rigid.MatPrint(mm)
+  fmt.Println("\nmax difference:", diff)
+
+  // forward dynamics, ABA vs CRBA
+  tau := base.GravityVector(nil, 9.81)
+  qdd, err := base.ForwardDynamics(qs, tau, nil, 9.81)
+  var qdd2 *mat.Dense
+  if err == nil {
+    qdd2, err = base.ForwardDynamicsCrba(qs, tau, nil, 9.81)
+  }
+  if err == nil {
+    fmt.Println("\nforward dynamics:")
+    rigid.MatPrint(qdd.T())
+    println()
+    rigid.MatPrint(qdd2.T())
+    println()
+  }
+
+  // simulation with gravity compensation
+  robot := sim.New(base, nil)
+  robot.Control = func(t float64, q, qd []float64) []float64 {
+    return mat.Col(nil, 0, base.GravityVector(nil, 9.81))
+  }
+  robot.Run(0.1)
+  fmt.Println("\nsimulation:", robot.T, robot.Q)
 }
\ No newline at end of file
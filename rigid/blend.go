@@ -0,0 +1,370 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Blend zone between the end of segment In and the start of segment Out
+// (-1 at the start and stop of motion), the first segment decelerates and
+// the second one accelerates with constant rates while their motions are
+// superposed, for straight segments it is the parabolic blend
+type BlendZone struct {
+  In, Out  int
+  Start    float64   // time
+  Dur      float64
+}
+
+// Timing of segments chained with blend zones,
+// parameter s of each segment changes from 0 to 1
+type blendTiming struct {
+  Rate   []float64    // ds/dt on the constant speed parts
+  Zones  []BlendZone
+  T      float64      // duration
+}
+
+// Parameter of the active segment and its time derivatives
+type blendState struct {
+  seg  int
+  s    [3]float64
+}
+
+// Plan timing for segments with the given lengths and maximal rates,
+// radius[k] is used for the corner between segments k and k+1 (zero or nil for a stop),
+// dur finds the minimal zone duration for the rates of the incoming and outgoing segments,
+// the rates are reduced when the zone is longer than the radius or the half of segment
+func planBlend(length, rate, radius []float64, dur func(in, out int, cin, cout float64) float64) blendTiming {
+  n := len(rate)
+  b := blendTiming{Rate: append([]float64{}, rate...)}
+  b.Zones = append(b.Zones, BlendZone{In: -1, Out: 0})
+  for k := 1; k < n; k++ {
+    if radius != nil && radius[k-1] > 0 {
+      b.Zones = append(b.Zones, BlendZone{In: k-1, Out: k})
+    } else {
+      b.Zones = append(b.Zones, BlendZone{In: k-1, Out: -1}, BlendZone{In: -1, Out: k})
+    }
+  }
+  b.Zones = append(b.Zones, BlendZone{In: n-1, Out: -1})
+  // part of the segment which can be used by the zone
+  limit := func(z *BlendZone, i int) float64 {
+    res := 0.5
+    if z.In >= 0 && z.Out >= 0 && radius[z.Out-1] < res*length[i] {
+      res = radius[z.Out-1] / length[i]
+    }
+    return res
+  }
+  for it := 0; it < 1000; it++ {
+    done := true
+    for k := range b.Zones {
+      z := &b.Zones[k]
+      cin, cout := b.rate(z.In), b.rate(z.Out)
+      t := dur(z.In, z.Out, cin, cout)
+      f := 1.0
+      if cin > 0 {
+        f = math.Min(f, limit(z, z.In) / (0.5*cin*t))
+      }
+      if cout > 0 {
+        f = math.Min(f, limit(z, z.Out) / (0.5*cout*t))
+      }
+      if f < 1 - 1E-9 {
+        // duration is proportional to rates, the zone length - to their squares
+        f = math.Sqrt(f)
+        if z.In >= 0 {
+          b.Rate[z.In] *= f
+        }
+        if z.Out >= 0 {
+          b.Rate[z.Out] *= f
+        }
+        done = false
+      }
+    }
+    if done {
+      break
+    }
+  }
+  for k := range b.Zones {
+    z := &b.Zones[k]
+    z.Dur = dur(z.In, z.Out, b.rate(z.In), b.rate(z.Out))
+  }
+  // time markers
+  for k := range b.Zones {
+    z := &b.Zones[k]
+    z.Start = b.T
+    b.T += z.Dur
+    if z.Out >= 0 {
+      // the next zone ends this segment
+      c := b.Rate[z.Out]
+      rest := 1 - 0.5*c*z.Dur - 0.5*c*b.Zones[k+1].Dur
+      b.T += math.Max(0, rest) / c
+    }
+  }
+  return b
+}
+
+// Corner radii for n segments: nil (stops everywhere) or radius[k]
+// for the corner between segments k and k+1
+func checkRadius(radius []float64, n int) bool {
+  return radius == nil || (n > 0 && len(radius) == n-1)
+}
+
+// Radius of the corner before segment i, segments without motion are skipped,
+// so the corner of the next moving segment is used
+func cornerRadius(radius []float64, i int) float64 {
+  if radius == nil {
+    return 0
+  }
+  return radius[i-1]
+}
+
+// Rate of the segment, zero when it is not defined
+func (b *blendTiming) rate(i int) float64 {
+  if i < 0 {
+    return 0
+  }
+  return b.Rate[i]
+}
+
+// States of the active segments at time t, two segments in the blend zone
+func (b *blendTiming) at(t float64) []blendState {
+  if len(b.Zones) == 0 {
+    return nil
+  }
+  t = math.Max(0, math.Min(b.T, t))
+  k := len(b.Zones) - 1
+  for k > 0 && b.Zones[k].Start > t {
+    k--
+  }
+  z := &b.Zones[k]
+  tau := t - z.Start
+  if tau > z.Dur {
+    if z.Out >= 0 {
+      // constant speed
+      c := b.Rate[z.Out]
+      return []blendState{{z.Out, [3]float64{c*(tau - 0.5*z.Dur), c, 0}}}
+    }
+    tau = z.Dur
+  }
+  var res []blendState
+  if z.In >= 0 {
+    st := blendState{seg: z.In, s: [3]float64{1, 0, 0}}
+    if c := b.Rate[z.In]; z.Dur > 0 {
+      st.s = [3]float64{1 - 0.5*c*z.Dur + c*(tau - 0.5*tau*tau/z.Dur), c*(1 - tau/z.Dur), -c/z.Dur}
+    }
+    res = append(res, st)
+  }
+  if z.Out >= 0 {
+    st := blendState{seg: z.Out}
+    if c := b.Rate[z.Out]; z.Dur > 0 {
+      st.s = [3]float64{0.5*c*tau*tau/z.Dur, c*tau/z.Dur, c/z.Dur}
+    }
+    res = append(res, st)
+  }
+  return res
+}
+
+// Motion duration
+func (b *blendTiming) Duration() float64 {
+  return b.T
+}
+
+// Joint motion through the points with parabolic blends at the corners
+type JointBlend struct {
+  Points  [][]float64
+  blendTiming
+}
+
+// Plan joint motion through the points with blends of the given radius
+// (joint space distance) in the intermediate points, zero radius means a stop,
+// velocity and acceleration limits are the same as for the point to point motion,
+// see checkRadius for the radius list, return nil when it is wrong
+func BlendJoints(mov []*Joint, points [][]float64, radius []float64, opt *PtpOptions) *JointBlend {
+  if len(points) == 0 || !checkRadius(radius, len(points)-1) {
+    return nil
+  }
+  vel := velLimits(mov, opt)
+  res := &JointBlend{Points: [][]float64{points[0]}}
+  var length, rate, rad []float64
+  for i := 1; i < len(points); i++ {
+    prev := res.Points[len(res.Points)-1]
+    d, c := 0.0, math.Inf(1)
+    for j := range prev {
+      dq := math.Abs(points[i][j] - prev[j])
+      d += dq*dq
+      if dq > 0 {
+        c = math.Min(c, vel[j] / dq)
+      }
+    }
+    if d == 0 {
+      // repeated point
+      continue
+    }
+    if len(rate) > 0 {
+      rad = append(rad, cornerRadius(radius, i-1))
+    }
+    res.Points = append(res.Points, points[i])
+    length, rate = append(length, math.Sqrt(d)), append(rate, c)
+  }
+  if len(rate) == 0 {
+    return res
+  }
+  res.blendTiming = planBlend(length, rate, rad, func(in, out int, cin, cout float64) float64 {
+    t := 0.0
+    for j := range vel {
+      dv := 0.0
+      if in >= 0 {
+        dv -= cin * (res.Points[in+1][j] - res.Points[in][j])
+      }
+      if out >= 0 {
+        dv += cout * (res.Points[out+1][j] - res.Points[out][j])
+      }
+      t = math.Max(t, math.Abs(dv) / opt.Acc)
+    }
+    return t
+  })
+  return res
+}
+
+// Joint positions, velocities and accelerations at time t,
+// the results can be nil
+func (b *JointBlend) At(t float64, q, qd, qdd []float64) {
+  st := b.at(t)
+  for j := range b.Points[0] {
+    x, v, a := b.Points[0][j], 0.0, 0.0
+    if len(st) > 0 {
+      x = 0
+    }
+    for _, s := range st {
+      p0, p1 := b.Points[s.seg][j], b.Points[s.seg+1][j]
+      x += p0 + (p1 - p0)*s.s[0]
+      v += (p1 - p0)*s.s[1]
+      a += (p1 - p0)*s.s[2]
+    }
+    if len(st) == 2 {
+      // both segments include the corner
+      x -= b.Points[st[1].seg][j]
+    }
+    if q != nil {
+      q[j] = x
+    }
+    if qd != nil {
+      qd[j] = v
+    }
+    if qdd != nil {
+      qdd[j] = a
+    }
+  }
+}
+
+// Sample motion in n+1 points,
+// return joint path with relative time in S and absolute time stamps
+func (b *JointBlend) Path(n int) (Path, []float64) {
+  return samplePath(func (t float64, q []float64) {
+    b.At(t, q, nil, nil)
+  }, len(b.Points[0]), 0, b.T, n)
+}
+
+// Cartesian motion along the segments with blends at the corners
+type CartBlend struct {
+  Moves  []CartMove
+  Step   float64      // time between samples
+  blendTiming
+}
+
+// Plan Cartesian motion with blends of the given radius (TCP distance to the corner),
+// zero radius means a stop, the segments should be connected,
+// limits are applied to the segment tangents at the corners,
+// see checkRadius for the radius list, return nil when it is wrong
+func BlendMoves(moves []CartMove, radius []float64, opt *MoveOptions) *CartBlend {
+  if !checkRadius(radius, len(moves)) {
+    return nil
+  }
+  res := &CartBlend{Step: opt.Step}
+  var length, rate, rad []float64
+  var vIn, wIn, vOut, wOut []*mat.Dense
+  for i, m := range moves {
+    lin, ang := m.Length()
+    c := math.Inf(1)
+    if lin > 0 {
+      c = opt.Vel / lin
+    }
+    if ang > 0 {
+      c = math.Min(c, opt.RotVel / ang)
+    }
+    if math.IsInf(c, 1) {
+      // no motion
+      continue
+    }
+    if len(rate) > 0 {
+      rad = append(rad, cornerRadius(radius, i))
+    }
+    res.Moves = append(res.Moves, m)
+    length, rate = append(length, lin), append(rate, c)
+    v, w := m.Rate(0)
+    vOut, wOut = append(vOut, v), append(wOut, w)
+    v, w = m.Rate(1)
+    vIn, wIn = append(vIn, v), append(wIn, w)
+  }
+  if len(rate) == 0 {
+    if len(moves) > 0 {
+      res.Moves = moves[:1]
+    }
+    return res
+  }
+  res.blendTiming = planBlend(length, rate, rad, func(in, out int, cin, cout float64) float64 {
+    dv, dw := zero31(), zero31()
+    if in >= 0 {
+      dv.Scale(-cin, vIn[in])
+      dw.Scale(-cin, wIn[in])
+    }
+    if out >= 0 {
+      var v, w mat.Dense
+      v.Scale(cout, vOut[out])
+      w.Scale(cout, wOut[out])
+      dv.Add(dv, &v)
+      dw.Add(dw, &w)
+    }
+    return math.Max(mat.Norm(dv, 2) / opt.Acc, mat.Norm(dw, 2) / opt.RotAcc)
+  })
+  return res
+}
+
+// Pose at time t, the blend is a superposition of the segment motions
+// w.r.t. the corner
+func (b *CartBlend) Pose(t float64) *Transform {
+  st := b.at(t)
+  if len(st) == 0 {
+    return b.Moves[0].Pose(0)
+  }
+  res := b.Moves[st[0].seg].Pose(st[0].s[0])
+  if len(st) == 2 {
+    m := b.Moves[st[1].seg]
+    c, p := m.Pose(0), m.Pose(st[1].s[0])
+    res.Pos.Add(res.Pos, p.Pos)
+    res.Pos.Sub(res.Pos, c.Pos)
+    var r mat.Dense
+    r.Mul(res.Rot, c.Rot.T())
+    res.Rot.Mul(&r, p.Rot)
+  }
+  return res
+}
+
+// Poses sampled in time, return poses and time stamps
+func (b *CartBlend) Sample() ([]Transform, []float64) {
+  n := int(math.Ceil(b.T / b.Step))
+  if n == 0 {
+    return []Transform{*b.Pose(0)}, []float64{0}
+  }
+  poses, times := make([]Transform, n+1), make([]float64, n+1)
+  for i := 0; i <= n; i++ {
+    times[i] = b.T * float64(i) / float64(n)
+    poses[i] = *b.Pose(times[i])
+  }
+  return poses, times
+}
+
+// Follow the blended Cartesian motion with IK from the current tracker state,
+// return joint path (with relative time in S) and time stamps
+func (tr *IkTracker) MoveBlend(b *CartBlend) (Path, []float64) {
+  poses, times := b.Sample()
+  return tr.follow(poses, times)
+}
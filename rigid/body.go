@@ -3,7 +3,9 @@ package rigid
 import (
   "../urdf" 
   "gonum.org/v1/gonum/mat"
-  //"fmt"
+  "math"
+  "fmt"
+  "errors"
 )
 
 type Link struct {
@@ -16,6 +18,15 @@ type Link struct {
   State         Transform
   // inertial parameters 
   Dyn           Inertial 
+  // tool frames 
+  Tools         []*Tool
+  Tool          *Tool        // active tool
+  // placement, root only 
+  World         *Frames
+  // actuators, root only
+  Drive         *Transmission
+  // loop closures, root only
+  Loops         []*Loop
 } 
 
 
@@ -30,6 +41,7 @@ func (src *Link) GetCopy() *Link {
   var dst Link 
   dst = *src
   // tree 
+  dst.Joints = make([]*Joint, len(src.Joints))
   for i, jnt := range src.Joints {
     jj := jnt.GetCopy() 
     jj.Parent = &dst
@@ -39,6 +51,26 @@ func (src *Link) GetCopy() *Link {
   // state
   dst.State.Reset()
   dst.State.Set(&src.State)
+  // tools
+  dst.Tools = nil
+  dst.Tool = nil
+  for _, t := range src.Tools {
+    tt := *t
+    tt.Link = &dst
+    tt.State.Reset()
+    tt.State.Set(&t.State)
+    dst.Tools = append(dst.Tools, &tt)
+    if t == src.Tool {
+      dst.Tool = &tt
+    }
+  }
+  if src.Drive != nil {
+    dst.Drive = src.Drive.copyFor(&dst)
+  }
+  dst.Loops = nil
+  for _, l := range src.Loops {
+    dst.Loops = append(dst.Loops, l.copyFor(&dst))
+  }
   
   return &dst
 }
@@ -63,6 +95,9 @@ func linkFromModel(m *urdf.Link) *Link {
 
 // Update chain parameters for the given joint states 
 func (v *Link) UpdateState(qs map[string][]float64) {
+  for _,t := range v.Tools {
+    t.update()
+  }
   // update next links
   for _,jnt := range v.Joints {
     lnk := jnt.Child 
@@ -104,8 +139,9 @@ func (ee *Link) Jacobian(mov []*Joint) *mat.Dense {
     mov = ee.Predecessors()
   }
   jac := jacEmpty(len(mov)) 
+  pos := ee.Frame().Pos         // active tool or link 
   for i,jnt := range mov {
-    jnt.Child.State.toColumn(jac, i, jnt.Type, ee.State.Pos)
+    jnt.Child.State.toColumn(jac, i, jnt.Type, pos)
   }
   return jac
 }
@@ -125,27 +161,34 @@ func (v *Link) Find(name string) *Link {
 }
 
 // Use recursive Newton-Euler dymanic calculation
-func (v *Link) rnea(w, dw, ae *mat.Dense) (*mat.Dense,*mat.Dense) {
+// ext contains external wrenches in link frames (can be nil)
+func (v *Link) rnea(w, dw, ae *mat.Dense, ext map[*Link]*mat.Dense) (*mat.Dense,*mat.Dense) {
   jnt := v.Parent 
+  dyn := v.inertial()           // including tool
   wi, dwi := jnt.getAngularAcc(w,dw) 
-  aci := jnt.getLinearAcc(ae, wi, dwi, v.Dyn.Rc) 
+  aci := jnt.getLinearAcc(ae, wi, dwi, dyn.Rc) 
   // force / torque
   var fi, taui, tmp, fc mat.Dense
-  fi.Scale(v.Dyn.M,aci)
-  taui.Mul(v.Dyn.I,dwi)
-  tmp.Mul(v.Dyn.I,wi);   taui.Add(&taui,Cross(wi,&tmp))
+  fi.Scale(dyn.M,aci)
+  taui.Mul(dyn.I,dwi)
+  tmp.Mul(dyn.I,wi);   taui.Add(&taui,Cross(wi,&tmp))
   // children 
   for _,jc := range v.Joints {
     aei := jnt.getLinearAcc(ae,wi,dwi, jc.Local.Pos) 
-    f, tau := jc.Child.rnea(wi,dwi,aei)
+    f, tau := jc.Child.rnea(wi,dwi,aei,ext)
     // force    
     fc.Mul(jc.Local.Rot,f)
     fi.Add(&fi,&fc)
     // torque
     tmp.Mul(jc.Local.Rot, tau);     taui.Add(&taui,&tmp)
-    tmp.Sub(v.Dyn.Rc,jc.Local.Pos); taui.Add(&taui,Cross(&fc,&tmp))
+    tmp.Sub(dyn.Rc,jc.Local.Pos); taui.Add(&taui,Cross(&fc,&tmp))
+  }
+  taui.Sub(&taui,Cross(&fi,dyn.Rc))
+  // external wrench
+  if fe, ok := ext[v]; ok {
+    taui.Sub(&taui, fe.Slice(0,3,0,1))
+    fi.Sub(&fi, fe.Slice(3,6,0,1))
   }
-  taui.Sub(&taui,Cross(&fi,v.Dyn.Rc))
 
   if jnt != nil {
     switch jnt.Type {
@@ -154,18 +197,30 @@ func (v *Link) rnea(w, dw, ae *mat.Dense) (*mat.Dense,*mat.Dense) {
     case joint_Tx, joint_Ty, joint_Tz:
       jnt.Tau = fi.At(int(jnt.Type),0) 
     }
-    // add friction
   }  
     
   return &fi, &taui  
 }
 
 // Find dymanical state using RNEA algorithm
+// gravity is directed along world Z
 func (base *Link) UpdateDyn(g float64) {
+  base.UpdateDynWith(Txyz(0, 0, -g), nil)
+}
+
+// Find dynamical state for the gravity vector in world frame (can be nil)
+// and external wrenches, return error if some wrench frame is not found
+// (joint torques are not changed)
+func (base *Link) UpdateDynWith(gv *mat.Dense, ext []Wrench) error {
+  fext, ok := base.wrenchMap(ext)
+  if !ok {
+    return errors.New("unknown wrench frame")
+  }
   zer := zero31()
-  acc := zero31()
-  acc.Set(2,0,g)  
-  base.rnea(zer, zer, acc)
+  base.rnea(zer, zer, base.gravityVector(gv), fext)
+  base.addFriction()
+  base.addRotor()
+  return nil
 }
 
 // Return joint torques in form of vector
@@ -195,10 +250,13 @@ type Joint struct {
   Trans         Transform     // constant transformation
   Local         Transform     // rotation from current to next 
   Limit         [2]float64
+  VelLimit      float64       // 0 when not defined
+  EffortLimit   float64       // 0 when not defined
   //Rij           *mat.Dense    // from current to next joint 
   Axis          *mat.Dense    // joint axis 
   // dynamics 
   Tau           float64 
+  Fric          Friction 
 }
 
 func (src *Joint) GetCopy() *Joint {
@@ -233,6 +291,10 @@ func jointFromModel(m *urdf.Joint) *Joint {
   } else {
     jnt.Type = joint_Fixed;
   } 
+  jnt.VelLimit = m.GetVelocity()
+  jnt.EffortLimit = m.GetEffort()
+  // friction from URDF 
+  jnt.Fric.Viscous, jnt.Fric.Coulomb = m.GetDynamics() 
   // transformation to next joint
   v := m.GetXyz() 
   jnt.Trans.Pos = Txyz(v[0],v[1],v[2]) 
@@ -256,12 +318,11 @@ func (jnt *Joint) UpdateLocal(q float64) {
     jnt.Local.Rot.Mul(jnt.Trans.Rot, Ry(q))
   case joint_Rz:
     jnt.Local.Rot.Mul(jnt.Trans.Rot, Rz(q))
-  case joint_Tx:
-    jnt.Local.Pos.Add(jnt.Trans.Pos, Txyz(q,0,0))
-  case joint_Ty:
-    jnt.Local.Pos.Add(jnt.Trans.Pos, Txyz(0,q,0))
-  case joint_Tz:
-    jnt.Local.Pos.Add(jnt.Trans.Pos, Txyz(0,0,q))
+  case joint_Tx, joint_Ty, joint_Tz:
+    // axis is defined after rotation
+    jnt.Local.Pos.Scale(q, jnt.Axis)
+    jnt.Local.Pos.Mul(jnt.Trans.Rot, jnt.Local.Pos)
+    jnt.Local.Pos.Add(jnt.Trans.Pos, jnt.Local.Pos)
   }
 }
 
@@ -285,10 +346,17 @@ func (jnt *Joint) getAngularAcc(wp, dwp *mat.Dense) (*mat.Dense,*mat.Dense) {
 }
 
 func (jnt *Joint) getLinearAcc(ap, wi, dwi, r *mat.Dense) *mat.Dense {
-   var ai mat.Dense   
-  // TODO: write for prismatic joint
+  var ai, tmp mat.Dense   
   if jnt != nil {
     ai.Mul(jnt.Local.Rot.T(), ap)
+    switch jnt.Type {
+    case joint_Tx, joint_Ty, joint_Tz:
+      // 2*w x (z*qd) + z*qdd 
+      tmp.Scale(2*jnt.Vel, jnt.Axis)
+      ai.Add(&ai, Cross(wi, &tmp))
+      tmp.Scale(jnt.Acc, jnt.Axis)
+      ai.Add(&ai, &tmp)
+    }
   } else {
     ai.Scale(1,ap)
   }
@@ -301,6 +369,22 @@ func (jnt *Joint) InRange(q float64) bool {
   return jnt.Limit[0] <= q && q <= jnt.Limit[1] 
 }
 
+// Shift angle of revolute joint by 2*pi to fit the limits,
+// joints without limits accept any value
+func (jnt *Joint) fitRange(q float64) (float64, bool) {
+  if jnt.Limit[0] >= jnt.Limit[1] || jnt.InRange(q) {
+    return q, true
+  }
+  if jnt.isRevolute() {
+    if jnt.InRange(q - 2*math.Pi) {
+      return q - 2*math.Pi, true
+    } else if jnt.InRange(q + 2*math.Pi) {
+      return q + 2*math.Pi, true
+    }
+  }
+  return q, false
+}
+
 
 func getJointAxis(tp JointType) *mat.Dense {
   switch tp {
@@ -322,32 +406,79 @@ func MakeJointMap(mov []*Joint) map[string][]float64 {
   return qs 
 }
 
-// Make tree of rigid body elements 
+// Make tree of rigid body elements, 
+// the first root in order of model links is returned, nil if the model is not correct 
 func BodyTree(model *urdf.Model) *Link {
+  trees, err := BodyTrees(model)
+  if err != nil || len(trees) == 0 {
+    return nil
+  }
+  return trees[0]
+}
+
+// Make all trees of the model in order of their roots in the list of links, 
+// return error for unknown or repeated links, several parents and cycles 
+func BodyTrees(model *urdf.Model) ([]*Link, error) {
   // read links 
   links := make(map[string]*Link)   
   for i := 0; i < len(model.Links); i++ {
-    lnk := linkFromModel(&model.Links[i])
-    links[ model.Links[i].Name ] = lnk     
+    name := model.Links[i].Name
+    if _, ok := links[name]; ok {
+      return nil, fmt.Errorf("link %s is repeated", name)
+    }
+    links[name] = linkFromModel(&model.Links[i])
   }
   // joints   
   for i := 0; i < len(model.Joints); i++ {
-    jnt := jointFromModel(&model.Joints[i])
-    lnk := links[ model.Joints[i].Parent.Name ] 
-    jnt.Parent = lnk     
-    lnk.Joints = append(lnk.Joints, jnt) 
-    
-    lnk = links[ model.Joints[i].Child.Name ]
-    lnk.Parent = jnt
-    jnt.Child = lnk     
+    src := &model.Joints[i]
+    parent, ok := links[ src.Parent.Name ]
+    if !ok {
+      return nil, fmt.Errorf("joint %s: unknown parent link %s", src.Name, src.Parent.Name)
+    }
+    child, ok := links[ src.Child.Name ]
+    if !ok {
+      return nil, fmt.Errorf("joint %s: unknown child link %s", src.Name, src.Child.Name)
+    }
+    if child.Parent != nil {
+      return nil, fmt.Errorf("link %s has several parents", src.Child.Name)
+    }
+    jnt := jointFromModel(src)
+    jnt.Parent = parent     
+    parent.Joints = append(parent.Joints, jnt) 
+    child.Parent = jnt
+    jnt.Child = child     
   }
-  // find "free" link 
-  for _,v := range links {
+  // find "free" links 
+  var res []*Link
+  root := make(map[*Link]*Link)
+  for i := 0; i < len(model.Links); i++ {
+    v := links[ model.Links[i].Name ]
     if v.Parent == nil {
-      return v       
+      res = append(res, v)
+      v.forEach(func (lnk *Link) {
+        root[lnk] = v
+      })
     }
   }
+  // links without root are in cycles 
+  for i := 0; i < len(model.Links); i++ {
+    if _, ok := root[ links[model.Links[i].Name] ]; !ok {
+      return nil, fmt.Errorf("link %s is in a cycle", model.Links[i].Name)
+    }
+  }
+  // loop closures 
+  for i := 0; i < len(model.Loops); i++ {
+    l, ok := loopFromModel(&model.Loops[i], links)
+    if !ok {
+      return nil, fmt.Errorf("loop %s is not correct", model.Loops[i].Name)
+    }
+    if root[l.A] != root[l.B] {
+      return nil, fmt.Errorf("loop %s connects different trees", l.Name)
+    }
+    root[l.A].Loops = append(root[l.A].Loops, l)
+  }
   
-  return nil
+  return res, nil
 } 
 
+
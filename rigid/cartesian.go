@@ -0,0 +1,203 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Cartesian segment of the TCP path
+type CartMove interface {
+  Pose(s float64) *Transform      // pose for 0 <= s <= 1
+  Length() (float64, float64)     // path length and rotation angle
+  Rate(s float64) (*mat.Dense, *mat.Dense)   // linear and angular velocity w.r.t. s
+}
+
+// Straight line, orientation is interpolated with SLERP
+type MoveL struct {
+  Start, End  Transform
+}
+
+// Circular arc through the via point, orientation is interpolated
+// with SLERP between the start and end (orientation of the via point is not used)
+type MoveC struct {
+  Start, End  Transform
+  Center      *mat.Dense
+  Axis        *mat.Dense    // rotation from the start to the end
+  Angle       float64
+}
+
+// Parameters of Cartesian motion
+type MoveOptions struct {
+  Vel, Acc        float64   // linear velocity and acceleration
+  RotVel, RotAcc  float64   // angular velocity and acceleration
+  Jerk            float64   // relative jerk (w.r.t. acceleration), trapezoidal profile when 0
+  Step            float64   // time between samples
+}
+
+// Default motion parameters
+func NewMoveOptions() *MoveOptions {
+  return &MoveOptions{Vel: 0.25, Acc: 1, RotVel: 1, RotAcc: 2, Step: 0.01}
+}
+
+// Line between two poses
+func NewMoveL(start, end *Transform) *MoveL {
+  return &MoveL{Start: *start.copy(), End: *end.copy()}
+}
+
+// Pose on the line
+func (m *MoveL) Pose(s float64) *Transform {
+  return Interpolate(&m.Start, &m.End, s)
+}
+
+// Line length and rotation angle
+func (m *MoveL) Length() (float64, float64) {
+  var d mat.Dense
+  d.Sub(m.End.Pos, m.Start.Pos)
+  return mat.Norm(&d, 2), rotationAngle(m.Start.Rot, m.End.Rot)
+}
+
+// Linear and angular (in world frame) velocity w.r.t. s
+func (m *MoveL) Rate(s float64) (*mat.Dense, *mat.Dense) {
+  v := zero31()
+  v.Sub(m.End.Pos, m.Start.Pos)
+  return v, rotationRate(m.Start.Rot, m.End.Rot)
+}
+
+// Angle between two orientations
+func rotationAngle(r0, r1 *mat.Dense) float64 {
+  var d mat.Dense
+  d.Mul(r0.T(), r1)
+  theta, _, _ := toAA(&d)
+  return theta
+}
+
+// Angular velocity of the interpolation from r0 to r1 w.r.t. s, in world frame
+func rotationRate(r0, r1 *mat.Dense) *mat.Dense {
+  var d mat.Dense
+  d.Mul(r0.T(), r1)
+  res := zero31()
+  if theta, r, ok := toAA(&d); ok {
+    res.Mul(r0, mat.NewDense(3, 1, r))
+    res.Scale(theta, res)
+  }
+  return res
+}
+
+// Arc from the start through the via point to the end,
+// return false when the points are on one line
+func NewMoveC(start, via, end *Transform) (*MoveC, bool) {
+  var a, b mat.Dense
+  a.Sub(via.Pos, start.Pos)
+  b.Sub(end.Pos, start.Pos)
+  n := Cross(&a, &b)
+  nn := mat.Dot(n.ColView(0), n.ColView(0))
+  if nn < 1E-12 * mat.Dot(a.ColView(0), a.ColView(0)) * mat.Dot(b.ColView(0), b.ColView(0)) {
+    return nil, false
+  }
+  // circumcenter: (|a|^2*b - |b|^2*a) x (a x b) / (2*|a x b|^2)
+  var c mat.Dense
+  c.Scale(mat.Dot(a.ColView(0), a.ColView(0)), &b)
+  a.Scale(mat.Dot(b.ColView(0), b.ColView(0)), &a)
+  c.Sub(&c, &a)
+  center := Cross(&c, n)
+  center.Scale(0.5/nn, center)
+  center.Add(center, start.Pos)
+  n.Scale(1/math.Sqrt(nn), n)
+  m := &MoveC{Start: *start.copy(), End: *end.copy(), Center: center, Axis: n}
+  m.Angle = m.angleTo(end.Pos)
+  return m, true
+}
+
+// Angle from the start point to p about the axis, in [0, 2*pi)
+func (m *MoveC) angleTo(p *mat.Dense) float64 {
+  var u0, u1 mat.Dense
+  u0.Sub(m.Start.Pos, m.Center)
+  u1.Sub(p, m.Center)
+  res := math.Atan2(mat.Dot(m.Axis.ColView(0), Cross(&u0, &u1).ColView(0)), mat.Dot(u0.ColView(0), u1.ColView(0)))
+  if res < 0 {
+    res += 2*math.Pi
+  }
+  return res
+}
+
+// Pose on the arc
+func (m *MoveC) Pose(s float64) *Transform {
+  res := Interpolate(&m.Start, &m.End, s)
+  r := fromAA(s*m.Angle, mat.Col(nil, 0, m.Axis))
+  res.Pos.Sub(m.Start.Pos, m.Center)
+  res.Pos.Mul(r, res.Pos)
+  res.Pos.Add(res.Pos, m.Center)
+  return res
+}
+
+// Linear and angular (in world frame) velocity w.r.t. s
+func (m *MoveC) Rate(s float64) (*mat.Dense, *mat.Dense) {
+  var u mat.Dense
+  u.Sub(m.Pose(s).Pos, m.Center)
+  v := Cross(m.Axis, &u)
+  v.Scale(m.Angle, v)
+  return v, rotationRate(m.Start.Rot, m.End.Rot)
+}
+
+// Arc length and rotation angle
+func (m *MoveC) Length() (float64, float64) {
+  var d mat.Dense
+  d.Sub(m.Start.Pos, m.Center)
+  return mat.Norm(&d, 2) * m.Angle, rotationAngle(m.Start.Rot, m.End.Rot)
+}
+
+// Normalized profile of the Cartesian segment with linear and angular limits
+func (opt *MoveOptions) profile(m CartMove) (normProfile, float64) {
+  lin, ang := m.Length()
+  v, a := math.Inf(1), math.Inf(1)
+  if lin > 0 {
+    v, a = opt.Vel / lin, opt.Acc / lin
+  }
+  if ang > 0 {
+    v, a = math.Min(v, opt.RotVel / ang), math.Min(a, opt.RotAcc / ang)
+  }
+  if math.IsInf(v, 1) {
+    return nil, 0
+  }
+  if opt.Jerk > 0 {
+    p := NewSCurve(1, 0, 0, v, a, opt.Jerk*a)
+    return p, p.T
+  }
+  p := Trapez(1, v, a)
+  return p, p.T
+}
+
+// Poses of the segment sampled in time, return poses and time stamps
+func (opt *MoveOptions) Sample(m CartMove) ([]Transform, []float64) {
+  p, tn := opt.profile(m)
+  if p == nil {
+    return []Transform{*m.Pose(0)}, []float64{0}
+  }
+  n := int(math.Ceil(tn / opt.Step))
+  poses, times := make([]Transform, n+1), make([]float64, n+1)
+  st := make([]float64, 3)
+  for i := 0; i <= n; i++ {
+    k := float64(i) / float64(n)
+    times[i] = p.At(k, tn, st)
+    poses[i] = *m.Pose(st[0])
+  }
+  return poses, times
+}
+
+// Follow the Cartesian segment with IK from the current tracker state,
+// return joint path (with relative time in S) and time stamps,
+// problems are saved into tracker events
+func (tr *IkTracker) Move(m CartMove, opt *MoveOptions) (Path, []float64) {
+  return tr.follow(opt.Sample(m))
+}
+
+// Track poses with time stamps, return path and time stamps
+func (tr *IkTracker) follow(poses []Transform, times []float64) (Path, []float64) {
+  path := tr.Track(poses, times)
+  tn := times[len(times)-1]
+  tm := make([]float64, len(path.S))
+  for i, s := range path.S {
+    tm[i] = s * tn
+  }
+  return path, tm
+}
@@ -0,0 +1,418 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Geometry primitives
+type ShapeType int
+const (
+  Shape_Sphere ShapeType = iota    // Size[0] is radius
+  Shape_Capsule                    // Size[0] is radius, Size[1] is length along Z
+  Shape_Box                        // Size is [x, y, z] lengths
+  Shape_Plane                      // half-space Z <= 0, environment only
+)
+
+// Shape with its pose
+type Shape struct {
+  Type   ShapeType
+  Size   [3]float64
+  Pose   Transform
+}
+
+// Collision shape of link, pose is in the link frame (zero pose is allowed)
+type Collider struct {
+  Link   *Link
+  Shape
+}
+
+// Static environment primitive, pose is in the named frame (world when empty),
+// zero pose is allowed
+type Obstacle struct {
+  Shape
+  Frame  string
+}
+
+// Contact between link and environment
+type Contact struct {
+  Link    *Link
+  Point   *mat.Dense   // contact point on the link surface, base frame
+  Normal  *mat.Dense   // from environment to link, base frame
+  Depth   float64      // penetration, positive
+}
+
+// Contact model parameters
+type ContactParams struct {
+  Stiffness  float64   // spring-damper model
+  Damping    float64
+  Mu         float64   // friction coefficient
+  Eps        float64   // velocity of regularized friction for spring-damper model
+  Iter       int       // projected Gauss-Seidel iterations
+  Erp        float64   // part of penetration removed in one step
+}
+
+// Default contact parameters
+func NewContactParams() ContactParams {
+  return ContactParams{Stiffness: 1E5, Damping: 1E3, Mu: 0.5, Eps: 1E-3, Iter: 50, Erp: 0.2}
+}
+
+// Make transform from position and RPY angles
+func makeTransform(xyz, rpy []float64) Transform {
+  return Transform{Pos: Txyz(xyz[0], xyz[1], xyz[2]), Rot: RPY(rpy[0], rpy[1], rpy[2])}
+}
+
+// Collision primitives of links from URDF (meshes are ignored),
+// cylinders are approximated with capsules
+func (base *Link) Colliders() []Collider {
+  var res []Collider
+  base.forEach(func (v *Link) {
+    if v.Src == nil {
+      return
+    }
+    tp, size, xyz, rpy := v.Src.GetCollision()
+    c := Collider{Link: v}
+    c.Pose = makeTransform(xyz, rpy)
+    switch tp {
+    case "box":
+      c.Type = Shape_Box
+      copy(c.Size[:], size)
+    case "cylinder":
+      c.Type = Shape_Capsule
+      c.Size[0], c.Size[1] = size[0], size[1]
+    case "sphere":
+      c.Type = Shape_Sphere
+      c.Size[0] = size[0]
+    default:
+      return
+    }
+    res = append(res, c)
+  })
+  return res
+}
+
+// Signed distance from point to the obstacle (pose in base frame) and its gradient
+func (s *Shape) distance(p *mat.Dense) (float64, *mat.Dense) {
+  var q mat.Dense
+  q.Sub(p, s.Pose.Pos)
+  q.Mul(s.Pose.Rot.T(), &q)
+  n := zero31()
+  var d float64
+  switch s.Type {
+  case Shape_Plane:
+    d = q.At(2,0)
+    n.Set(2, 0, 1)
+  case Shape_Sphere:
+    d = mat.Norm(&q, 2) - s.Size[0]
+    n.Copy(&q)
+  case Shape_Capsule:
+    h := 0.5*s.Size[1]
+    z := math.Max(-h, math.Min(h, q.At(2,0)))
+    n.Copy(&q)
+    n.Set(2, 0, q.At(2,0) - z)
+    d = mat.Norm(n, 2) - s.Size[0]
+  case Shape_Box:
+    out := 0.0
+    in := math.Inf(-1)
+    axis := 0
+    for k := 0; k < 3; k++ {
+      a := math.Abs(q.At(k,0)) - 0.5*s.Size[k]
+      if a > 0 {
+        out += a*a
+        n.Set(k, 0, math.Copysign(a, q.At(k,0)))
+      }
+      if a > in {
+        in, axis = a, k
+      }
+    }
+    if out > 0 {
+      d = math.Sqrt(out)
+    } else {
+      // inside, the closest face
+      d = in
+      n.Set(axis, 0, math.Copysign(1, q.At(axis,0)))
+    }
+  }
+  if nrm := mat.Norm(n, 2); nrm > 0 {
+    n.Scale(1/nrm, n)
+  } else {
+    n.Set(2, 0, 1)
+  }
+  n.Mul(s.Pose.Rot, n)
+  return d, n
+}
+
+// Points of the collider in base frame with radius,
+// ref is the obstacle position for the capsule segment
+func (c *Collider) points(ref *mat.Dense) ([]*mat.Dense, float64) {
+  var pose Transform
+  pose.Reset()
+  pose.Set(&c.Link.State)
+  if c.Pose.Rot != nil {
+    pose.Apply(&c.Pose)
+  }
+  at := func(x, y, z float64) *mat.Dense {
+    p := zero31()
+    p.Mul(pose.Rot, Txyz(x, y, z))
+    p.Add(p, pose.Pos)
+    return p
+  }
+  switch c.Type {
+  case Shape_Sphere:
+    return []*mat.Dense{at(0, 0, 0)}, c.Size[0]
+  case Shape_Capsule:
+    h := 0.5*c.Size[1]
+    res := []*mat.Dense{at(0, 0, -h), at(0, 0, h)}
+    if ref != nil {
+      // the closest point of the segment
+      var q mat.Dense
+      q.Sub(ref, pose.Pos)
+      q.Mul(pose.Rot.T(), &q)
+      res = append(res, at(0, 0, math.Max(-h, math.Min(h, q.At(2,0)))))
+    }
+    return res, c.Size[0]
+  case Shape_Box:
+    var res []*mat.Dense
+    x, y, z := 0.5*c.Size[0], 0.5*c.Size[1], 0.5*c.Size[2]
+    for k := 0; k < 8; k++ {
+      sx, sy, sz := float64(k&1*2-1), float64(k>>1&1*2-1), float64(k>>2&1*2-1)
+      res = append(res, at(sx*x, sy*y, sz*z))
+    }
+    return res, 0
+  }
+  return nil, 0
+}
+
+// Find contacts between colliders and obstacles for the current tree state,
+// obstacles with unknown frames are ignored
+func (base *Link) FindContacts(cols []Collider, obs []Obstacle) []Contact {
+  var res []Contact
+  for i := range obs {
+    frame := obs[i].Frame
+    if frame == "" {
+      frame = Frame_World
+    }
+    sh := obs[i].Shape
+    r, p := eye33(), zero31()
+    if sh.Pose.Rot != nil {
+      r, p = sh.Pose.Rot, sh.Pose.Pos
+    }
+    r, p, ok := base.ToBase(frame, r, p)
+    if !ok {
+      continue
+    }
+    sh.Pose = Transform{Rot: r, Pos: p}
+    var ref *mat.Dense
+    if sh.Type != Shape_Plane {
+      ref = p
+    }
+    for k := range cols {
+      pts, rad := cols[k].points(ref)
+      for _, pt := range pts {
+        d, n := sh.distance(pt)
+        if d >= rad {
+          continue
+        }
+        cp := zero31()
+        cp.Scale(-rad, n)
+        cp.Add(cp, pt)
+        res = append(res, Contact{Link: cols[k].Link, Point: cp, Normal: n, Depth: rad - d})
+      }
+    }
+  }
+  return res
+}
+
+// Jacobian of a point attached to link, base frame,
+// columns are in order of mov
+func (v *Link) pointJacobian(mov []*Joint, p *mat.Dense) *mat.Dense {
+  ind := jointIndex(mov)
+  jac := jacEmpty(len(mov))
+  for _, jnt := range v.Predecessors() {
+    if i, ok := ind[jnt]; ok {
+      jnt.Child.State.toColumn(jac, i, jnt.Type, p)
+    }
+  }
+  return jac
+}
+
+// Linear velocity of the contact point for the current joint velocities
+func (base *Link) contactVelocity(c *Contact, mov []*Joint) *mat.Dense {
+  jac := c.Link.pointJacobian(mov, c.Point)
+  qd := mat.NewDense(len(mov), 1, nil)
+  for i, jnt := range mov {
+    qd.Set(i, 0, jnt.Vel)
+  }
+  var v mat.Dense
+  v.Mul(jac.Slice(0,3,0,len(mov)), qd)
+  return &v
+}
+
+// Contact forces of the spring-damper model with regularized Coulomb friction
+// for the current tree state
+func (base *Link) ContactWrenches(contacts []Contact, par *ContactParams) []Wrench {
+  mov := base.MovableJoints()
+  res := make([]Wrench, 0, len(contacts))
+  for i := range contacts {
+    c := &contacts[i]
+    v := base.contactVelocity(c, mov)
+    vn := mat.Dot(v.ColView(0), c.Normal.ColView(0))
+    fn := par.Stiffness*c.Depth - par.Damping*vn
+    if fn <= 0 {
+      continue
+    }
+    f := zero31()
+    f.Scale(fn, c.Normal)
+    // tangential velocity
+    var vt mat.Dense
+    vt.Scale(vn, c.Normal)
+    vt.Sub(v, &vt)
+    if s := mat.Norm(&vt, 2); s > 0 && par.Mu > 0 {
+      vt.Scale(-par.Mu*fn / math.Sqrt(s*s + par.Eps*par.Eps), &vt)
+      f.Add(f, &vt)
+    }
+    res = append(res, Wrench{Link: c.Link, Force: f, Frame: Frame_Base, Point: c.Point})
+  }
+  return res
+}
+
+// Orthonormal tangent vectors for the normal
+func tangents(n *mat.Dense) (*mat.Dense, *mat.Dense) {
+  a := Txyz(1, 0, 0)
+  if math.Abs(n.At(0,0)) > 0.9 {
+    a = Txyz(0, 1, 0)
+  }
+  t1 := Cross(n, a)
+  t1.Scale(1/mat.Norm(t1, 2), t1)
+  return t1, Cross(n, t1)
+}
+
+// Unilateral constraints J*qd >= 0 for the time-stepping scheme
+type Constraints struct {
+  J      *mat.Dense   // constraint rows
+  Bias   []float64    // desired minimal velocity for each row
+  Mu     []float64    // friction for the first row of each block
+  Size   []int        // block sizes: 1 (unilateral) or 3 (contact with friction)
+}
+
+// Add rows to constraint matrix
+func (cs *Constraints) add(rows [][]float64, bias []float64, mu float64) {
+  n := len(rows[0])
+  r := 0
+  if cs.J != nil {
+    r, _ = cs.J.Dims()
+  }
+  j := mat.NewDense(r + len(rows), n, nil)
+  if cs.J != nil {
+    matInsert(0,0, j, cs.J)
+  }
+  for i, row := range rows {
+    j.SetRow(r+i, row)
+  }
+  cs.J = j
+  cs.Bias = append(cs.Bias, bias...)
+  cs.Mu = append(cs.Mu, mu)
+  cs.Size = append(cs.Size, len(rows))
+}
+
+// Constraints for contacts (normal and two tangent directions) and joint limits,
+// h is the time step, v are joint velocities without constraints
+// (limits are skipped when v is nil), joint is limited when it can pass
+// the limit during the step
+func (base *Link) MakeConstraints(contacts []Contact, v *mat.Dense, par *ContactParams, h float64) *Constraints {
+  mov := base.MovableJoints()
+  n := len(mov)
+  cs := new(Constraints)
+  for i := range contacts {
+    c := &contacts[i]
+    jac := c.Link.pointJacobian(mov, c.Point).Slice(0,3,0,n)
+    t1, t2 := tangents(c.Normal)
+    var rows [3]mat.Dense
+    rows[0].Mul(c.Normal.T(), jac)
+    rows[1].Mul(t1.T(), jac)
+    rows[2].Mul(t2.T(), jac)
+    cs.add([][]float64{mat.Row(nil, 0, &rows[0]), mat.Row(nil, 0, &rows[1]), mat.Row(nil, 0, &rows[2])},
+      []float64{par.Erp*c.Depth/h, 0, 0}, par.Mu)
+  }
+  if v == nil {
+    return cs
+  }
+  // distance to the limit / h, penetration is reduced partially
+  bias := func(d float64) float64 {
+    if d < 0 {
+      return -par.Erp*d/h
+    }
+    return -d/h
+  }
+  for i, jnt := range mov {
+    lo, up := jnt.Limit[0], jnt.Limit[1]
+    if lo >= up {
+      continue
+    }
+    q, qn := jnt.Angle, jnt.Angle + h*v.At(i,0)
+    row := make([]float64, n)
+    if qn < lo {
+      row[i] = 1
+      cs.add([][]float64{row}, []float64{bias(q - lo)}, 0)
+    } else if qn > up {
+      row[i] = -1
+      cs.add([][]float64{row}, []float64{bias(up - q)}, 0)
+    }
+  }
+  return cs
+}
+
+// Projected Gauss-Seidel for the impulses: A*lambda + b >= 0, lambda >= 0,
+// with complementarity, friction impulses are projected on the cone |lt| <= mu*ln
+func (cs *Constraints) Pgs(a, b *mat.Dense, iter int) *mat.Dense {
+  m, _ := b.Dims()
+  lam := mat.NewDense(m, 1, nil)
+  for it := 0; it < iter; it++ {
+    r := 0
+    for k, size := range cs.Size {
+      for i := r; i < r+size; i++ {
+        if a.At(i,i) <= 0 {
+          continue
+        }
+        w := b.At(i,0) + mat.Dot(a.RowView(i), lam.ColView(0))
+        lam.Set(i, 0, lam.At(i,0) - w/a.At(i,i))
+        if i == r {
+          lam.Set(i, 0, math.Max(0, lam.At(i,0)))
+        }
+      }
+      if size == 3 {
+        // friction cone
+        lim := cs.Mu[k] * lam.At(r,0)
+        t1, t2 := lam.At(r+1,0), lam.At(r+2,0)
+        if s := math.Hypot(t1, t2); s > lim {
+          if s > 0 {
+            lam.Set(r+1, 0, t1*lim/s)
+            lam.Set(r+2, 0, t2*lim/s)
+          }
+        }
+      }
+      r += size
+    }
+  }
+  return lam
+}
+
+// Joint velocities after impulses for the free velocity v,
+// minv is the inverse mass matrix
+func (cs *Constraints) Solve(minv, v *mat.Dense, iter int) *mat.Dense {
+  if cs.J == nil {
+    return v
+  }
+  var mj, a, b mat.Dense
+  mj.Mul(minv, cs.J.T())
+  a.Mul(cs.J, &mj)
+  b.Mul(cs.J, v)
+  for i, bias := range cs.Bias {
+    b.Set(i, 0, b.At(i,0) - bias)
+  }
+  lam := cs.Pgs(&a, &b, iter)
+  var res mat.Dense
+  res.Mul(&mj, lam)
+  res.Add(&res, v)
+  return &res
+}
@@ -0,0 +1,146 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+)
+
+// Collect all movable joints of the tree, parents before children
+func (base *Link) MovableJoints() []*Joint {
+  var res []*Joint
+  base.forEach(func (v *Link) {
+    if v.Parent != nil && v.Parent.Type != joint_Fixed {
+      res = append(res, v.Parent)
+    }
+  })
+  return res
+}
+
+// Indices of joints in list
+func jointIndex(mov []*Joint) map[*Joint]int {
+  res := make(map[*Joint]int)
+  for i, jnt := range mov {
+    res[jnt] = i
+  }
+  return res
+}
+
+// Composite inertia of the subtree in the link frame
+func (v *Link) composite(acc map[*Link]*mat.Dense) *mat.Dense {
+  res := v.inertial().spatial()
+  for _, jnt := range v.Joints {
+    ic := jnt.Child.composite(acc)
+    res.Add(res, toParent(jnt.xform(), ic))
+  }
+  acc[v] = res
+  return res
+}
+
+// Joint space inertia matrix with composite rigid body algorithm,
+// use all movable joints when mov is nil
+// Tree state must be updated before
+func (base *Link) MassMatrix(mov []*Joint) *mat.Dense {
+  all := base.MovableJoints()
+  ind := jointIndex(all)
+  ic := make(map[*Link]*mat.Dense)
+  base.composite(ic)
+  n := len(all)
+  h := mat.NewDense(n, n, nil)
+  var f mat.Dense
+  for i, jnt := range all {
+    s := jnt.subspace()
+    f.Mul(ic[jnt.Child], s)
+    h.Set(i, i, mat.Dot(f.ColView(0), s.ColView(0)))
+    // move to the base
+    for p := jnt; p.Parent.Parent != nil; {
+      f.Mul(p.xform().T(), &f)
+      p = p.Parent.Parent
+      if j, ok := ind[p]; ok {
+        hij := mat.Dot(f.ColView(0), p.subspace().ColView(0))
+        h.Set(i, j, hij)
+        h.Set(j, i, hij)
+      }
+    }
+  }
+  if base.Drive != nil {
+    base.Drive.addInertia(h, ind)
+  }
+  if mov == nil {
+    return h
+  }
+  return subMatrix(h, ind, mov)
+}
+
+// Select rows and columns for the given joints
+func subMatrix(h *mat.Dense, ind map[*Joint]int, mov []*Joint) *mat.Dense {
+  res := mat.NewDense(len(mov), len(mov), nil)
+  for i, ji := range mov {
+    for j, jj := range mov {
+      res.Set(i, j, h.At(ind[ji], ind[jj]))
+    }
+  }
+  return res
+}
+
+// Run RNEA with the current joint state,
+// velocities and accelerations can be ignored, joint parameters are restored
+func (base *Link) rneaWith(useVel, useAcc bool, acc *mat.Dense, ext map[*Link]*mat.Dense, mov []*Joint) *mat.Dense {
+  all := base.MovableJoints()
+  type saved struct { vel, acc, tau float64 }
+  prev := make([]saved, len(all))
+  for i, jnt := range all {
+    prev[i] = saved{jnt.Vel, jnt.Acc, jnt.Tau}
+    if !useVel {
+      jnt.Vel = 0
+    }
+    if !useAcc {
+      jnt.Acc = 0
+    }
+  }
+  zer := zero31()
+  base.rnea(zer, zer, acc, ext)
+  base.addRotor()
+  if mov == nil {
+    mov = all
+  }
+  res := ReadTorques(mov)
+  for i, jnt := range all {
+    jnt.Vel, jnt.Acc, jnt.Tau = prev[i].vel, prev[i].acc, prev[i].tau
+  }
+  return res
+}
+
+// Gravity torques g(q), use all movable joints when mov is nil
+func (base *Link) GravityVector(mov []*Joint, g float64) *mat.Dense {
+  return base.rneaWith(false, false, base.gravity(g), nil, mov)
+}
+
+// Coriolis and centrifugal torques C(q,qd)*qd,
+// use all movable joints when mov is nil
+func (base *Link) CoriolisVector(mov []*Joint) *mat.Dense {
+  return base.rneaWith(true, false, zero31(), nil, mov)
+}
+
+// Mass matrix from RNEA with unit accelerations (for verification)
+func (base *Link) MassMatrixRnea(mov []*Joint) *mat.Dense {
+  if mov == nil {
+    mov = base.MovableJoints()
+  }
+  n := len(mov)
+  h := mat.NewDense(n, n, nil)
+  all := base.MovableJoints()
+  prev := make([]float64, len(all))
+  for i, jnt := range all {
+    prev[i] = jnt.Acc
+    jnt.Acc = 0
+  }
+  for j, jnt := range mov {
+    jnt.Acc = 1
+    col := base.rneaWith(false, true, zero31(), nil, mov)
+    matInsert(0, j, h, col)
+    jnt.Acc = 0
+  }
+  for i, jnt := range all {
+    jnt.Acc = prev[i]
+  }
+  return h
+}
@@ -0,0 +1,152 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+)
+
+// All values are found for the current tree state in the base frame,
+// active tools are included
+
+// Total mass of the tree
+func (base *Link) TotalMass() float64 {
+  res := 0.0
+  base.forEach(func (v *Link) {
+    res += v.inertial().M
+  })
+  return res
+}
+
+// Mass and first moment m*c of subtrees in the base frame
+func (v *Link) firstMoment(m map[*Link]float64, mc map[*Link]*mat.Dense) {
+  dyn := v.inertial()
+  c := mat.NewDense(3,1,nil)
+  c.Mul(v.State.Rot, dyn.Rc)
+  c.Add(c, v.State.Pos)
+  c.Scale(dyn.M, c)
+  mass := dyn.M
+  for _, jnt := range v.Joints {
+    jnt.Child.firstMoment(m, mc)
+    mass += m[jnt.Child]
+    c.Add(c, mc[jnt.Child])
+  }
+  m[v], mc[v] = mass, c
+}
+
+// Center of mass of the whole tree
+func (base *Link) MassCenter() *mat.Dense {
+  m := make(map[*Link]float64)
+  mc := make(map[*Link]*mat.Dense)
+  base.firstMoment(m, mc)
+  res := zero31()
+  if m[base] > 0 {
+    res.Scale(1/m[base], mc[base])
+  }
+  return res
+}
+
+// Jacobian of the mass center (3 x n), use all movable joints when mov is nil
+func (base *Link) MassCenterJacobian(mov []*Joint) *mat.Dense {
+  if mov == nil {
+    mov = base.MovableJoints()
+  }
+  m := make(map[*Link]float64)
+  mc := make(map[*Link]*mat.Dense)
+  base.firstMoment(m, mc)
+  res := mat.NewDense(3, len(mov), nil)
+  if m[base] == 0 {
+    return res
+  }
+  // subtree of joint moves as a single body
+  jac := jacEmpty(len(mov))
+  var c mat.Dense
+  for i, jnt := range mov {
+    lnk := jnt.Child
+    if m[lnk] == 0 {
+      continue
+    }
+    c.Scale(1/m[lnk], mc[lnk])
+    lnk.State.toColumn(jac, i, jnt.Type, &c)
+    for k := 0; k < 3; k++ {
+      res.Set(k, i, jac.At(k,i) * m[lnk] / m[base])
+    }
+  }
+  return res
+}
+
+// Spatial velocities of links in link frames
+func (base *Link) velocities() map[*Link]*mat.Dense {
+  vel := make(map[*Link]*mat.Dense)
+  acc := make(map[*Link]*mat.Dense)
+  vel[base] = mat.NewDense(6,1,nil)
+  base.motion(vel[base], mat.NewDense(6,1,nil), vel, acc)
+  return vel
+}
+
+// Kinetic energy of links and reflected rotor inertia
+func (base *Link) KineticEnergy() float64 {
+  res := 0.0
+  var h mat.Dense
+  for v, vi := range base.velocities() {
+    h.Mul(v.inertial().spatial(), vi)
+    res += 0.5 * mat.Dot(vi.ColView(0), h.ColView(0))
+  }
+  if tr := base.Drive; tr != nil {
+    r := tr.Reflected()
+    for i, ji := range tr.Joints {
+      for j, jj := range tr.Joints {
+        res += 0.5 * ji.Vel * r.At(i,j) * jj.Vel
+      }
+    }
+  }
+  return res
+}
+
+// Potential energy in the gravity field (world Z), zero at the base origin
+func (base *Link) PotentialEnergy(g float64) float64 {
+  m := make(map[*Link]float64)
+  mc := make(map[*Link]*mat.Dense)
+  base.firstMoment(m, mc)
+  // fake acceleration is directed upwards
+  return mat.Dot(base.gravity(g).ColView(0), mc[base].ColView(0))
+}
+
+// Transformation of force from link frame to the frame
+// at the mass center c with base orientation
+func centroidalForce(v *Link, c *mat.Dense) *mat.Dense {
+  var p mat.Dense
+  p.Sub(v.State.Pos, c)
+  return plucker(v.State.Rot, &p)
+}
+
+// Spatial momentum [angular; linear] w.r.t. the mass center
+func (base *Link) Momentum() *mat.Dense {
+  c := base.MassCenter()
+  res := mat.NewDense(6,1,nil)
+  var h, f mat.Dense
+  for v, vi := range base.velocities() {
+    h.Mul(v.inertial().spatial(), vi)
+    f.Mul(centroidalForce(v, c).T(), &h)
+    res.Add(res, &f)
+  }
+  return res
+}
+
+// Centroidal momentum matrix A (6 x n): h = A*qd,
+// use all movable joints when mov is nil
+func (base *Link) CentroidalMomentum(mov []*Joint) *mat.Dense {
+  if mov == nil {
+    mov = base.MovableJoints()
+  }
+  c := base.MassCenter()
+  ic := make(map[*Link]*mat.Dense)
+  base.composite(ic)
+  res := mat.NewDense(6, len(mov), nil)
+  var f mat.Dense
+  for i, jnt := range mov {
+    lnk := jnt.Child
+    f.Mul(ic[lnk], jnt.subspace())
+    f.Mul(centroidalForce(lnk, c).T(), &f)
+    res.SetCol(i, mat.Col(nil, 0, &f))
+  }
+  return res
+}
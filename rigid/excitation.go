@@ -0,0 +1,254 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+  "math/rand"
+)
+
+// Periodic excitation trajectory for identification,
+// q_i(t) = Q0_i + sum_l A_il/(W*l)*sin(W*l*t) - B_il/(W*l)*cos(W*l*t) + P_i(t),
+// polynomial P_i gives zero velocity and acceleration at the start and end,
+// joints are in order of MovableJoints
+type Excitation struct {
+  W     float64        // base frequency
+  Q0    []float64      // central position
+  A, B  [][]float64    // coefficients [joint][harmonic]
+  P     []Polynomial   // start and end correction
+}
+
+// Parameters of excitation design
+type ExcitationOptions struct {
+  Harmonics  int        // number of harmonics
+  Period     float64    // trajectory duration
+  Samples    int        // points for the condition number
+  Iter       int        // optimization iterations
+  Margin     float64    // relative distance to the position limits
+  Vel        float64    // velocity limit when it is not defined in URDF
+  Acc        float64    // acceleration limit, 0 if not used
+  Friction   bool       // include friction parameters
+  Seed       int64
+}
+
+// Default design parameters
+func NewExcitationOptions() *ExcitationOptions {
+  return &ExcitationOptions{Harmonics: 5, Period: 10, Samples: 50, Iter: 300,
+    Margin: 0.05, Vel: 1, Seed: 1}
+}
+
+// Trajectory duration
+func (e *Excitation) Period() float64 {
+  return 2*math.Pi / e.W
+}
+
+// Joint positions, velocities and accelerations at time t,
+// the results can be nil
+func (e *Excitation) At(t float64, q, qd, qdd []float64) {
+  for i := range e.Q0 {
+    var p, v, a float64
+    for l := range e.A[i] {
+      wl := e.W * float64(l+1)
+      s, c := math.Sincos(wl*t)
+      p += (e.A[i][l]*s - e.B[i][l]*c) / wl
+      v += e.A[i][l]*c + e.B[i][l]*s
+      a += (-e.A[i][l]*s + e.B[i][l]*c) * wl
+    }
+    if e.P != nil {
+      p += e.P[i].Val(t)
+      v += e.P[i].Val1d(t)
+      a += e.P[i].Val2d(t)
+    }
+    if q != nil {
+      q[i] = e.Q0[i] + p
+    }
+    if qd != nil {
+      qd[i] = v
+    }
+    if qdd != nil {
+      qdd[i] = a
+    }
+  }
+}
+
+// Find quintic polynomials that compensate the series at t = 0 and t = T,
+// the trajectory starts and ends at Q0 with zero velocity and acceleration
+func (e *Excitation) correct() {
+  tn := e.Period()
+  n := len(e.Q0)
+  e.P = nil
+  q, qd, qdd := make([]float64, n), make([]float64, n), make([]float64, n)
+  e.At(0, q, qd, qdd)
+  // p(t) = c0 + c1*t + c2/2*t^2 + a3*t^3 + a4*t^4 + a5*t^5,
+  // the series is periodic, so p must have the same values at t = T
+  t2, t3 := tn*tn, tn*tn*tn
+  m := mat.NewDense(3,3, []float64{
+    t3,   t2*t2,   t3*t2,
+    3*t2, 4*t3,    5*t2*t2,
+    6*tn, 12*t2,   20*t3})
+  var lu mat.LU
+  lu.Factorize(m)
+  res := make([]Polynomial, n)
+  var x mat.Dense
+  for i := range res {
+    c0, c1, c2 := e.Q0[i] - q[i], -qd[i], -qdd[i]
+    rhs := mat.NewDense(3,1, []float64{
+      -c1*tn - 0.5*c2*t2,
+      -c2*tn,
+      0})
+    lu.SolveTo(&x, false, rhs)
+    res[i] = Polynomial{x.At(2,0), x.At(1,0), x.At(0,0), 0.5*c2, c1, c0}
+  }
+  e.P = res
+}
+
+// Scale coefficients and shift the center to satisfy the limits,
+// the trajectory is checked in n points
+func (e *Excitation) fit(mov []*Joint, opt *ExcitationOptions, n int) {
+  e.correct()
+  k := len(e.Q0)
+  lo, up := make([]float64, k), make([]float64, k)
+  vmax, amax := make([]float64, k), make([]float64, k)
+  q, qd, qdd := make([]float64, k), make([]float64, k), make([]float64, k)
+  for i := range lo {
+    lo[i], up[i] = math.Inf(1), math.Inf(-1)
+  }
+  tn := e.Period()
+  for s := 0; s <= n; s++ {
+    e.At(tn*float64(s)/float64(n), q, qd, qdd)
+    for i := range q {
+      d := q[i] - e.Q0[i]
+      lo[i], up[i] = math.Min(lo[i], d), math.Max(up[i], d)
+      vmax[i] = math.Max(vmax[i], math.Abs(qd[i]))
+      amax[i] = math.Max(amax[i], math.Abs(qdd[i]))
+    }
+  }
+  for i, jnt := range mov {
+    scale := 1.0
+    vlim := jnt.VelLimit
+    if vlim <= 0 {
+      vlim = opt.Vel
+    }
+    if vmax[i] > vlim {
+      scale = vlim / vmax[i]
+    }
+    if opt.Acc > 0 && amax[i]*scale > opt.Acc {
+      scale = opt.Acc / amax[i]
+    }
+    if jl, ju := jnt.Limit[0], jnt.Limit[1]; jl < ju {
+      m := 0.5 * opt.Margin * (ju - jl)
+      jl, ju = jl + m, ju - m
+      if w := (up[i] - lo[i]) * scale; w > ju - jl {
+        scale *= (ju - jl) / w
+      }
+      // keep the whole motion inside the range
+      e.Q0[i] = math.Max(jl - scale*lo[i], math.Min(ju - scale*up[i], e.Q0[i]))
+    }
+    for l := range e.A[i] {
+      e.A[i][l] *= scale
+      e.B[i][l] *= scale
+    }
+  }
+  e.correct()
+}
+
+// Set tree state for time t
+func (e *Excitation) setState(base *Link, mov []*Joint, qs map[string][]float64, t float64) {
+  n := len(mov)
+  q, qd, qdd := make([]float64, n), make([]float64, n), make([]float64, n)
+  e.At(t, q, qd, qdd)
+  base.setSample(mov, qs, &DynSample{Q: q, Qd: qd, Qdd: qdd})
+}
+
+// Condition number of the base regressor along the trajectory
+func (base *Link) excitationCond(e *Excitation, bp *BaseParams, g float64, opt *ExcitationOptions) float64 {
+  mov := base.MovableJoints()
+  n := len(mov)
+  qs := MakeJointMap(mov)
+  var w *mat.Dense
+  for s := 0; s < opt.Samples; s++ {
+    e.setState(base, mov, qs, e.Period()*float64(s)/float64(opt.Samples))
+    y := bp.Columns(base.regressorWith(g, opt.Friction))
+    if w == nil {
+      _, c := y.Dims()
+      w = mat.NewDense(n*opt.Samples, c, nil)
+    }
+    matInsert(s*n, 0, w, y)
+  }
+  return mat.Cond(w, 2)
+}
+
+// Design excitation trajectory with minimal condition number of the base regressor,
+// position, velocity and acceleration limits are respected,
+// return the trajectory and the condition number
+func (base *Link) Excitation(g float64, opt *ExcitationOptions) (*Excitation, float64) {
+  if opt == nil {
+    opt = NewExcitationOptions()
+  }
+  mov := base.MovableJoints()
+  bp := base.FindBaseParams(g, opt.Friction)
+  rnd := rand.New(rand.NewSource(opt.Seed))
+  check := 100*opt.Harmonics
+  // initial guess
+  e := &Excitation{W: 2*math.Pi / opt.Period, Q0: make([]float64, len(mov))}
+  e.A, e.B = make([][]float64, len(mov)), make([][]float64, len(mov))
+  for i, jnt := range mov {
+    if lo, up := jnt.Limit[0], jnt.Limit[1]; lo < up {
+      e.Q0[i] = 0.5*(lo + up)
+    }
+    e.A[i], e.B[i] = make([]float64, opt.Harmonics), make([]float64, opt.Harmonics)
+    for l := range e.A[i] {
+      e.A[i][l], e.B[i][l] = rnd.NormFloat64(), rnd.NormFloat64()
+    }
+  }
+  e.fit(mov, opt, check)
+  best := base.excitationCond(e, bp, g, opt)
+  // (1+1) evolution strategy with the 1/5 success rule
+  sigma := 0.3
+  for it := 0; it < opt.Iter; it++ {
+    cand := e.copy()
+    for i, jnt := range mov {
+      // coefficients are scaled w.r.t. the current amplitude
+      amp := 0.0
+      for l := range cand.A[i] {
+        amp = math.Max(amp, math.Max(math.Abs(cand.A[i][l]), math.Abs(cand.B[i][l])))
+      }
+      if amp == 0 {
+        amp = 1
+      }
+      for l := range cand.A[i] {
+        cand.A[i][l] += sigma * amp * rnd.NormFloat64()
+        cand.B[i][l] += sigma * amp * rnd.NormFloat64()
+      }
+      if lo, up := jnt.Limit[0], jnt.Limit[1]; lo < up {
+        cand.Q0[i] += 0.5 * sigma * (up - lo) * rnd.NormFloat64()
+      }
+    }
+    cand.fit(mov, opt, check)
+    if c := base.excitationCond(cand, bp, g, opt); c < best {
+      e, best = cand, c
+      sigma *= 1.5
+    } else {
+      sigma *= 0.9
+    }
+    sigma = math.Max(1E-3, math.Min(1, sigma))
+  }
+  return e, best
+}
+
+// Deep copy
+func (e *Excitation) copy() *Excitation {
+  res := &Excitation{W: e.W, Q0: append([]float64{}, e.Q0...)}
+  for i := range e.A {
+    res.A = append(res.A, append([]float64{}, e.A[i]...))
+    res.B = append(res.B, append([]float64{}, e.B[i]...))
+  }
+  return res
+}
+
+// Sample trajectory in n+1 points,
+// return joint path with relative time in S and absolute time stamps
+func (e *Excitation) Path(n int) (Path, []float64) {
+  return samplePath(func (t float64, q []float64) {
+    e.At(t, q, nil, nil)
+  }, len(e.Q0), 0, e.Period(), n)
+}
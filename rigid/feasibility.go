@@ -0,0 +1,202 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Kind of limit
+type LimitType int
+const (
+  Limit_Position LimitType = iota
+  Limit_Velocity
+  Limit_Effort
+  Limit_Power                        // motor power, transmission is required
+)
+
+// Limit violation on the time interval [T0, T1]
+type Violation struct {
+  Type    LimitType
+  Joint   int        // index in MovableJoints
+  T0, T1  float64
+  T       float64    // time of the worst value
+  Value   float64    // the worst value
+  Margin  float64    // distance to the limit, negative
+}
+
+// Usage of the joint along the trajectory
+type JointUsage struct {
+  Rms       float64   // RMS joint torque
+  Peak      float64   // maximal absolute joint torque
+  Vel       float64   // maximal absolute velocity
+  Power     float64   // maximal power (motor power if transmission is defined)
+  MotorRms  float64   // RMS motor torque, when transmission is defined
+  Margin    [4]float64   // minimal margin for each LimitType, +Inf when not limited
+}
+
+// Result of the trajectory check
+type Feasibility struct {
+  Violations  []Violation
+  Joints      []JointUsage    // in order of MovableJoints
+}
+
+// Trajectory is feasible
+func (f *Feasibility) Ok() bool {
+  return len(f.Violations) == 0
+}
+
+// Joint state from positions with finite differences,
+// times must be increasing
+func SamplesFromPath(path Path, times []float64) []DynSample {
+  n := len(path.Joints)
+  res := make([]DynSample, n)
+  if n == 0 {
+    return res
+  }
+  k := len(path.Joints[0])
+  for i := range res {
+    res[i] = DynSample{Q: append([]float64{}, path.Joints[i]...),
+      Qd: make([]float64, k), Qdd: make([]float64, k)}
+  }
+  if n < 2 {
+    return res
+  }
+  if n == 2 {
+    for j := 0; j < k; j++ {
+      v := (path.Joints[1][j] - path.Joints[0][j]) / (times[1] - times[0])
+      res[0].Qd[j], res[1].Qd[j] = v, v
+    }
+    return res
+  }
+  for i := 0; i < n; i++ {
+    // three points with the i-th in the middle when possible
+    m := i
+    if m == 0 {
+      m = 1
+    } else if m == n-1 {
+      m = n-2
+    }
+    h1, h2 := times[m] - times[m-1], times[m+1] - times[m]
+    for j := 0; j < k; j++ {
+      q0, q1, q2 := path.Joints[m-1][j], path.Joints[m][j], path.Joints[m+1][j]
+      // parabola through three points
+      a := 2*(h1*q2 - (h1+h2)*q1 + h2*q0) / (h1*h2*(h1+h2))
+      v := (h1*h1*(q2-q1) + h2*h2*(q1-q0)) / (h1*h2*(h1+h2))
+      res[i].Qd[j] = v + a*(times[i] - times[m])
+      res[i].Qdd[j] = a
+    }
+  }
+  return res
+}
+
+// Check limits along the trajectory with RNEA in each sample,
+// joint values are in order of MovableJoints, motor values
+// are not found when the transmission matrix is singular
+func (base *Link) CheckTrajectory(times []float64, samples []DynSample, g float64) *Feasibility {
+  mov := base.MovableJoints()
+  n := len(mov)
+  qs := MakeJointMap(mov)
+  res := &Feasibility{Joints: make([]JointUsage, n)}
+  for i := range res.Joints {
+    for k := range res.Joints[i].Margin {
+      res.Joints[i].Margin[k] = math.Inf(1)
+    }
+  }
+  // currently open violations
+  open := make([][4]int, n)
+  for i := range open {
+    open[i] = [4]int{-1, -1, -1, -1}
+  }
+  check := func(tp LimitType, i int, t, val, lim float64) {
+    u := &res.Joints[i]
+    m := lim - math.Abs(val)
+    if tp == Limit_Position {
+      m = math.Min(val - mov[i].Limit[0], mov[i].Limit[1] - val)
+    }
+    u.Margin[tp] = math.Min(u.Margin[tp], m)
+    k := open[i][tp]
+    if m >= 0 {
+      open[i][tp] = -1
+      return
+    }
+    if k < 0 {
+      res.Violations = append(res.Violations, Violation{Type: tp, Joint: i,
+        T0: t, T1: t, T: t, Value: val, Margin: m})
+      open[i][tp] = len(res.Violations)-1
+      return
+    }
+    v := &res.Violations[k]
+    v.T1 = t
+    if m < v.Margin {
+      v.T, v.Value, v.Margin = t, val, m
+    }
+  }
+  tr := base.Drive
+  ind := jointIndex(mov)
+  sum := make([]float64, n)
+  msum := make([]float64, n)
+  for s := range samples {
+    base.setSample(mov, qs, &samples[s])
+    base.UpdateDyn(g)
+    t := times[s]
+    // weight for the trapezoidal rule
+    w := 0.0
+    if s > 0 {
+      w += 0.5*(t - times[s-1])
+    }
+    if s+1 < len(samples) {
+      w += 0.5*(times[s+1] - t)
+    }
+    for i, jnt := range mov {
+      u := &res.Joints[i]
+      u.Peak = math.Max(u.Peak, math.Abs(jnt.Tau))
+      u.Vel = math.Max(u.Vel, math.Abs(jnt.Vel))
+      sum[i] += w * jnt.Tau * jnt.Tau
+      if lo, up := jnt.Limit[0], jnt.Limit[1]; lo < up {
+        check(Limit_Position, i, t, jnt.Angle, 0)
+      }
+      if jnt.VelLimit > 0 {
+        check(Limit_Velocity, i, t, jnt.Vel, jnt.VelLimit)
+      }
+      if jnt.EffortLimit > 0 {
+        check(Limit_Effort, i, t, jnt.Tau, jnt.EffortLimit)
+      }
+    }
+    // motor side, G can be singular with coupling
+    var tm, wm *mat.Dense
+    if tr != nil {
+      m := len(tr.Joints)
+      qd, qdd := mat.NewDense(m,1,nil), mat.NewDense(m,1,nil)
+      for k, jnt := range tr.Joints {
+        qd.Set(k, 0, jnt.Vel)
+        qdd.Set(k, 0, jnt.Acc)
+      }
+      tm = tr.MotorTorque(ReadTorques(tr.Joints), qd, qdd)
+      wm = tr.ToMotor(qd)
+    }
+    if tm == nil {
+      for i, jnt := range mov {
+        res.Joints[i].Power = math.Max(res.Joints[i].Power, math.Abs(jnt.Tau*jnt.Vel))
+      }
+      continue
+    }
+    for k, jnt := range tr.Joints {
+      i := ind[jnt]
+      u := &res.Joints[i]
+      p := tm.At(k,0) * wm.At(k,0)
+      u.Power = math.Max(u.Power, math.Abs(p))
+      msum[i] += w * tm.At(k,0) * tm.At(k,0)
+      if lim := tr.Motors[k].Power; lim > 0 {
+        check(Limit_Power, i, t, p, lim)
+      }
+    }
+  }
+  if len(times) > 1 {
+    dur := times[len(times)-1] - times[0]
+    for i := range res.Joints {
+      res.Joints[i].Rms = math.Sqrt(sum[i] / dur)
+      res.Joints[i].MotorRms = math.Sqrt(msum[i] / dur)
+    }
+  }
+  return res
+}
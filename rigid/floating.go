@@ -0,0 +1,141 @@
+package rigid
+
+import (
+  "errors"
+  "gonum.org/v1/gonum/mat"
+)
+
+// Floating base: the root link moves with twist vb = [w; v] and
+// spatial acceleration ab = [dw; dv - w x v] in the base frame,
+// base orientation w.r.t. world is taken from World.Base (gravity direction),
+// equations of motion: M*[ab; qdd] + C = [fb; tau]
+
+// Inverse dynamics with floating base for the current joint state,
+// joint torques are saved into joints (with friction),
+// return the base wrench [n; f] or error if some wrench frame is not found
+// (joint torques are not changed)
+func (base *Link) FloatingRnea(vb, ab *mat.Dense, g float64, ext []Wrench) (*mat.Dense, error) {
+  fext, ok := base.wrenchMap(ext)
+  if !ok {
+    return nil, errors.New("unknown wrench frame")
+  }
+  vel := make(map[*Link]*mat.Dense)
+  acc := make(map[*Link]*mat.Dense)
+  // gravity as fake acceleration
+  a0 := mat.NewDense(6,1,nil)
+  matInsert(3,0, a0, base.gravity(g))
+  a0.Add(a0, ab)
+  vel[base], acc[base] = vb, a0
+  base.motion(vb, a0, vel, acc)
+  fb := base.floatingForce(vel, acc, fext)
+  base.addFriction()
+  base.addRotor()
+  return fb, nil
+}
+
+// Backward pass of RNEA, set joint torques and return force of the link
+func (v *Link) floatingForce(vel, acc, fext map[*Link]*mat.Dense) *mat.Dense {
+  in := v.inertial().spatial()
+  f := mat.NewDense(6,1,nil)
+  f.Mul(in, acc[v])
+  var tmp mat.Dense
+  tmp.Mul(in, vel[v])
+  tmp.Mul(crossForce(vel[v]), &tmp)
+  f.Add(f, &tmp)
+  if fe, ok := fext[v]; ok {
+    f.Sub(f, fe)
+  }
+  for _, jnt := range v.Joints {
+    fc := jnt.Child.floatingForce(vel, acc, fext)
+    if jnt.Type != joint_Fixed {
+      jnt.Tau = mat.Dot(jnt.subspace().ColView(0), fc.ColView(0))
+    }
+    tmp.Mul(jnt.xform().T(), fc)
+    f.Add(f, &tmp)
+  }
+  return f
+}
+
+// Joint space inertia with floating base, (6+n) x (6+n) matrix
+// [Ic F; F^T H], Ic is the composite inertia of the tree in base frame,
+// F is the coupling between the base and joints in order of MovableJoints
+func (base *Link) FloatingMassMatrix() *mat.Dense {
+  mov := base.MovableJoints()
+  n := len(mov)
+  ic := make(map[*Link]*mat.Dense)
+  base.composite(ic)
+  res := mat.NewDense(6+n, 6+n, nil)
+  matInsert(0,0, res, ic[base])
+  matInsert(6,6, res, base.MassMatrix(nil))
+  f := base.BaseCoupling()
+  matInsert(0,6, res, f)
+  matInsert(6,0, res, f.T())
+  return res
+}
+
+// Coupling between base and joints (6 x n): force on base for unit joint accelerations
+func (base *Link) BaseCoupling() *mat.Dense {
+  mov := base.MovableJoints()
+  ic := make(map[*Link]*mat.Dense)
+  base.composite(ic)
+  res := mat.NewDense(6, len(mov), nil)
+  var f mat.Dense
+  for i, jnt := range mov {
+    f.Mul(ic[jnt.Child], jnt.subspace())
+    // move to the base
+    for p := jnt; p != nil; p = p.Parent.Parent {
+      f.Mul(p.xform().T(), &f)
+    }
+    res.SetCol(i, mat.Col(nil, 0, &f))
+  }
+  return res
+}
+
+// Forward dynamics with floating base and the given base twist,
+// torques and joint accelerations are in order of MovableJoints,
+// return the base spatial acceleration and joint accelerations,
+// off-diagonal terms of the reflected rotor inertia are ignored
+func (base *Link) FloatingForwardDynamics(qs map[string][]float64, vb, tau *mat.Dense, ext []Wrench, g float64) (*mat.Dense, *mat.Dense, error) {
+  base.UpdateState(qs)
+  mov := base.MovableJoints()
+  setTorques(mov, tau)
+  fext, ok := base.wrenchMap(ext)
+  if !ok {
+    return nil, nil, errors.New("unknown wrench frame")
+  }
+  data := make(map[*Link]*abaData)
+  root := &abaData{v: vb}
+  data[base] = root
+  root.ia = base.inertial().spatial()
+  root.pa = mat.NewDense(6,1,nil)
+  root.pa.Mul(root.ia, vb)
+  root.pa.Mul(crossForce(vb), root.pa)
+  if fe, ok := fext[base]; ok {
+    root.pa.Sub(root.pa, fe)
+  }
+  base.abaVelocity(data, fext)
+  var arm map[*Joint]float64
+  if base.Drive != nil {
+    arm = base.Drive.armature()
+  }
+  base.abaInertia(data, arm)
+  // base acceleration with gravity as fake acceleration: IA*a0 + pA = 0
+  a0 := mat.NewDense(6,1,nil)
+  var rhs mat.Dense
+  rhs.Scale(-1, root.pa)
+  if err := a0.Solve(root.ia, &rhs); err != nil {
+    return nil, nil, err
+  }
+  base.abaAcceleration(data, a0)
+  qdd := mat.NewDense(len(mov),1,nil)
+  for i, jnt := range mov {
+    qdd.Set(i, 0, jnt.Acc)
+  }
+  // remove gravity
+  ab := mat.DenseCopyOf(a0)
+  ag := base.gravity(g)
+  for k := 0; k < 3; k++ {
+    ab.Set(3+k, 0, ab.At(3+k,0) - ag.At(k,0))
+  }
+  return ab, saveAcc(mov, qdd, qs), nil
+}
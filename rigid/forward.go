@@ -0,0 +1,190 @@
+package rigid
+
+import (
+  "errors"
+  "gonum.org/v1/gonum/mat"
+)
+
+// Intermediate values of articulated body algorithm
+type abaData struct {
+  v, c   *mat.Dense   // velocity and velocity product acceleration
+  ia, pa *mat.Dense   // articulated inertia and bias force
+  u      *mat.Dense   // IA*S
+  d, tau float64      // S^T*IA*S and reduced torque
+}
+
+// Joint torques in the order of movable joints
+func setTorques(mov []*Joint, tau *mat.Dense) {
+  for i, jnt := range mov {
+    if tau != nil {
+      jnt.Tau = tau.At(i,0)
+    } else {
+      jnt.Tau = 0
+    }
+  }
+}
+
+// Save accelerations into joint map and return them as vector
+func saveAcc(mov []*Joint, qdd *mat.Dense, qs map[string][]float64) *mat.Dense {
+  for i, jnt := range mov {
+    jnt.Acc = qdd.At(i,0)
+    if q, ok := qs[jnt.Src.Name]; ok {
+      q[2] = jnt.Acc
+    }
+  }
+  return qdd
+}
+
+// Joint accelerations for the given state, torques and external wrenches
+// with articulated body algorithm, torques and result are in order of MovableJoints,
+// accelerations are also saved into the joint map,
+// return error for wrenches in unknown frames
+func (base *Link) ForwardDynamics(qs map[string][]float64, tau *mat.Dense, ext []Wrench, g float64) (*mat.Dense, error) {
+  base.UpdateState(qs)
+  mov := base.MovableJoints()
+  fext, ok := base.wrenchMap(ext)
+  if !ok {
+    return nil, errors.New("unknown wrench frame")
+  }
+  setTorques(mov, tau)
+  a0 := mat.NewDense(6,1,nil)
+  matInsert(3,0, a0, base.gravity(g))
+  qdd := base.aba(mov, fext, a0)
+  if base.Drive != nil && base.Drive.coupled() {
+    qdd = base.Drive.correctAcc(base, mov, qdd)
+  }
+  return saveAcc(mov, qdd, qs), nil
+}
+
+// Articulated body algorithm for the current state and joint torques,
+// a0 is the base acceleration, reflected rotor inertia is diagonal
+func (base *Link) aba(mov []*Joint, fext map[*Link]*mat.Dense, a0 *mat.Dense) *mat.Dense {
+  data := make(map[*Link]*abaData)
+  // velocities
+  root := &abaData{v: mat.NewDense(6,1,nil)}
+  data[base] = root
+  root.ia = base.inertial().spatial()
+  root.pa = mat.NewDense(6,1,nil)
+  base.abaVelocity(data, fext)
+  // articulated inertia
+  var arm map[*Joint]float64
+  if base.Drive != nil {
+    arm = base.Drive.armature()
+  }
+  base.abaInertia(data, arm)
+  // accelerations
+  base.abaAcceleration(data, a0)
+
+  qdd := mat.NewDense(len(mov),1,nil)
+  for i, jnt := range mov {
+    qdd.Set(i, 0, jnt.Acc)
+  }
+  return qdd
+}
+
+// First pass: velocities and bias forces
+func (v *Link) abaVelocity(data map[*Link]*abaData, fext map[*Link]*mat.Dense) {
+  vp := data[v].v
+  for _, jnt := range v.Joints {
+    lnk := jnt.Child
+    d := new(abaData)
+    var vj mat.Dense
+    vj.Scale(jnt.Vel, jnt.subspace())
+    d.v = mat.NewDense(6,1,nil)
+    d.v.Mul(jnt.xform(), vp)
+    d.v.Add(d.v, &vj)
+    d.c = mat.NewDense(6,1,nil)
+    d.c.Mul(crossMotion(d.v), &vj)
+    d.ia = lnk.inertial().spatial()
+    d.pa = mat.NewDense(6,1,nil)
+    d.pa.Mul(d.ia, d.v)
+    d.pa.Mul(crossForce(d.v), d.pa)
+    if fe, ok := fext[lnk]; ok {
+      d.pa.Sub(d.pa, fe)
+    }
+    data[lnk] = d
+    lnk.abaVelocity(data, fext)
+  }
+}
+
+// Second pass: articulated inertia and bias forces,
+// arm is the reflected rotor inertia of joints (can be nil)
+func (v *Link) abaInertia(data map[*Link]*abaData, arm map[*Joint]float64) {
+  parent := data[v]
+  for _, jnt := range v.Joints {
+    lnk := jnt.Child
+    lnk.abaInertia(data, arm)
+    d := data[lnk]
+    ia := mat.DenseCopyOf(d.ia)
+    pa := mat.DenseCopyOf(d.pa)
+    var uu, f mat.Dense
+    if jnt.Type != joint_Fixed {
+      s := jnt.subspace()
+      d.u = mat.NewDense(6,1,nil)
+      d.u.Mul(d.ia, s)
+      d.d = mat.Dot(s.ColView(0), d.u.ColView(0)) + arm[jnt]
+      d.tau = jnt.Tau - jnt.Fric.Torque(jnt.Vel) - mat.Dot(s.ColView(0), d.pa.ColView(0))
+      // Ia = IA - U U^T / D, pa = pA + U u / D
+      uu.Mul(d.u, d.u.T())
+      uu.Scale(1/d.d, &uu)
+      ia.Sub(ia, &uu)
+      f.Scale(d.tau/d.d, d.u)
+      pa.Add(pa, &f)
+    }
+    f.Mul(ia, d.c)
+    pa.Add(pa, &f)
+    x := jnt.xform()
+    parent.ia.Add(parent.ia, toParent(x, ia))
+    f.Mul(x.T(), pa)
+    parent.pa.Add(parent.pa, &f)
+  }
+}
+
+// Third pass: accelerations
+func (v *Link) abaAcceleration(data map[*Link]*abaData, ap *mat.Dense) {
+  for _, jnt := range v.Joints {
+    lnk := jnt.Child
+    d := data[lnk]
+    a := mat.NewDense(6,1,nil)
+    a.Mul(jnt.xform(), ap)
+    a.Add(a, d.c)
+    if jnt.Type != joint_Fixed {
+      jnt.Acc = (d.tau - mat.Dot(d.u.ColView(0), a.ColView(0))) / d.d
+      var tmp mat.Dense
+      tmp.Scale(jnt.Acc, jnt.subspace())
+      a.Add(a, &tmp)
+    }
+    lnk.abaAcceleration(data, a)
+  }
+}
+
+// Joint accelerations from mass matrix and bias torques (Cholesky decomposition),
+// arguments and result are the same as for ForwardDynamics
+func (base *Link) ForwardDynamicsCrba(qs map[string][]float64, tau *mat.Dense, ext []Wrench, g float64) (*mat.Dense, error) {
+  base.UpdateState(qs)
+  mov := base.MovableJoints()
+  m := base.MassMatrix(mov)
+  fext, ok := base.wrenchMap(ext)
+  if !ok {
+    return nil, errors.New("unknown wrench frame")
+  }
+  // bias torques with friction
+  bias := base.rneaWith(true, false, base.gravity(g), fext, mov)
+  rhs := mat.NewDense(len(mov),1,nil)
+  for i, jnt := range mov {
+    t := 0.0
+    if tau != nil {
+      t = tau.At(i,0)
+    }
+    rhs.Set(i, 0, t - bias.At(i,0) - jnt.Fric.Torque(jnt.Vel))
+  }
+  var chol mat.Cholesky
+  if !chol.Factorize(mat.NewSymDense(len(mov), m.RawMatrix().Data)) {
+    return nil, errors.New("mass matrix is not positive definite")
+  }
+  qdd := mat.NewDense(len(mov),1,nil)
+  if err := chol.SolveTo(qdd, rhs); err != nil {
+    return nil, err
+  }
+  return saveAcc(mov, qdd, qs), nil
+}
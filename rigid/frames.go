@@ -0,0 +1,164 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Reserved frame names
+const (
+  Frame_World = "world"
+  Frame_Base  = "base"
+)
+
+// Robot placement in the cell
+type Frames struct {
+  Base  Transform               // base w.r.t. world
+  Work  map[string]*Transform   // work objects w.r.t. world
+}
+
+// Get frames of the tree, create if need
+func (base *Link) frames() *Frames {
+  if base.World == nil {
+    base.World = new(Frames)
+    base.World.Base.Reset()
+    base.World.Work = make(map[string]*Transform)
+  }
+  return base.World
+}
+
+// Set base position and RPY orientation w.r.t. world
+func (base *Link) SetMounting(xyz, rpy []float64) {
+  fr := base.frames()
+  fr.Base.Pos = Txyz(xyz[0], xyz[1], xyz[2])
+  fr.Base.Rot = RPY(rpy[0], rpy[1], rpy[2])
+}
+
+// Add work object frame w.r.t. world
+func (base *Link) AddWorkObject(name string, xyz, rpy []float64) {
+  var t Transform
+  t.Pos = Txyz(xyz[0], xyz[1], xyz[2])
+  t.Rot = RPY(rpy[0], rpy[1], rpy[2])
+  base.frames().Work[name] = &t
+}
+
+// Frame w.r.t. base, return false if it is not found
+func (base *Link) frameInBase(name string) (*Transform, bool) {
+  var res Transform
+  res.Reset()
+  if name == Frame_Base {
+    return &res, true
+  }
+  fr := base.World
+  var wt *Transform      // frame w.r.t. world
+  if name != Frame_World {
+    if fr == nil || fr.Work[name] == nil {
+      return nil, false
+    }
+    wt = fr.Work[name]
+  }
+  // inverse of base pose
+  if fr != nil {
+    res.Rot.Copy(fr.Base.Rot.T())
+    res.Pos.Mul(res.Rot, fr.Base.Pos)
+    res.Pos.Scale(-1, res.Pos)
+  }
+  if wt != nil {
+    res.Apply(wt)
+  }
+  return &res, true
+}
+
+// Convert pose from the named frame into base frame
+func (base *Link) ToBase(frame string, rot, pos *mat.Dense) (*mat.Dense, *mat.Dense, bool) {
+  t, ok := base.frameInBase(frame)
+  if !ok {
+    return nil, nil, false
+  }
+  r := eye33()
+  r.Mul(t.Rot, rot)
+  p := zero31()
+  p.Mul(t.Rot, pos)
+  p.Add(p, t.Pos)
+  return r, p, true
+}
+
+// Convert pose from base frame into the named frame
+func (base *Link) FromBase(frame string, rot, pos *mat.Dense) (*mat.Dense, *mat.Dense, bool) {
+  t, ok := base.frameInBase(frame)
+  if !ok {
+    return nil, nil, false
+  }
+  r := eye33()
+  r.Mul(t.Rot.T(), rot)
+  p := zero31()
+  p.Sub(pos, t.Pos)
+  p.Mul(t.Rot.T(), p)
+  return r, p, true
+}
+
+// Current pose of the link (or its active tool) in the named frame
+func (base *Link) PoseIn(frame string, lnk *Link) (*Transform, bool) {
+  st := lnk.Frame()
+  r, p, ok := base.FromBase(frame, st.Rot, st.Pos)
+  if !ok {
+    return nil, false
+  }
+  return &Transform{Rot: r, Pos: p}, true
+}
+
+// Convert list of poses from the named frame into base frame
+func (base *Link) PosesToBase(frame string, poses []Transform) ([]Transform, bool) {
+  res := make([]Transform, len(poses))
+  for i := range poses {
+    r, p, ok := base.ToBase(frame, poses[i].Rot, poses[i].Pos)
+    if !ok {
+      return nil, false
+    }
+    res[i] = Transform{Rot: r, Pos: p}
+  }
+  return res, true
+}
+
+// Gravity acceleration in base frame, Z axis of world is vertical
+func (base *Link) gravity(g float64) *mat.Dense {
+  return base.gravityVector(Txyz(0, 0, -g))
+}
+
+// Acceleration of base equivalent to the gravity vector in world frame
+func (base *Link) gravityVector(gv *mat.Dense) *mat.Dense {
+  acc := zero31()
+  if gv == nil {
+    return acc
+  }
+  acc.Scale(-1, gv)
+  if base.World != nil {
+    acc.Mul(base.World.Base.Rot.T(), acc)
+  }
+  return acc
+}
+
+// Inverse kinematics for target in the named frame
+type FrameIk struct {
+  IkSolver
+  Base   *Link
+  Name   string      // frame name
+}
+
+// Wrap solver to use targets in the given frame
+func InFrame(solver IkSolver, base *Link, frame string) *FrameIk {
+  return &FrameIk{IkSolver: solver, Base: base, Name: frame}
+}
+
+// Inverse kinematics for the pose in the frame,
+// all solutions are NaN when the frame is not found
+func (par *FrameIk) IkFull(rot, pos *mat.Dense) {
+  r, p, ok := par.Base.ToBase(par.Name, rot, pos)
+  if !ok {
+    par.Solutions().Apply(func(i, j int, v float64) float64 {
+      return math.NaN()
+    }, par.Solutions())
+    return
+  }
+  par.IkSolver.IkFull(r, p)
+}
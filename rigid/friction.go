@@ -0,0 +1,58 @@
+package rigid
+
+import (
+  "math"
+)
+
+// Joint friction model:
+// tau = (Fc + (Fs - Fc)*exp(-(qd/Vs)^2)) * sign(qd) + B*qd
+type Friction struct {
+  Viscous   float64   // B, damping
+  Coulomb   float64   // Fc
+  Static    float64   // Fs, Stribeck effect when Fs > Fc
+  Vs        float64   // Stribeck velocity
+  Eps       float64   // smooth sign function for |qd| < Eps
+}
+
+// Friction torque (force) for the given velocity
+func (f *Friction) Torque(qd float64) float64 {
+  var s float64
+  if f.Eps > 0 {
+    s = math.Tanh(qd / f.Eps)
+  } else if qd > 0 {
+    s = 1
+  } else if qd < 0 {
+    s = -1
+  }
+  fc := f.Coulomb
+  if f.Vs > 0 && f.Static > f.Coulomb {
+    v := qd / f.Vs
+    fc += (f.Static - f.Coulomb) * math.Exp(-v*v)
+  }
+  return fc*s + f.Viscous*qd
+}
+
+// Set friction model for joint with the given name,
+// return false if joint is not found
+func (base *Link) SetFriction(name string, f Friction) bool {
+  found := false
+  base.forEach(func (v *Link) {
+    for _, jnt := range v.Joints {
+      if jnt.Src.Name == name {
+        jnt.Fric = f
+        found = true
+      }
+    }
+  })
+  return found
+}
+
+// Add friction to the joint torques
+func (v *Link) addFriction() {
+  for _, jnt := range v.Joints {
+    if jnt.Type != joint_Fixed {
+      jnt.Tau += jnt.Fric.Torque(jnt.Vel)
+    }
+    jnt.Child.addFriction()
+  }
+}
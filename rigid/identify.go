@@ -0,0 +1,345 @@
+package rigid
+
+import (
+  "errors"
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Logged state and measured torques in order of MovableJoints
+type DynSample struct {
+  Q    []float64
+  Qd   []float64
+  Qdd  []float64
+  Tau  []float64
+}
+
+// Identification of dynamical parameters from samples
+type Identification struct {
+  Base      *Link
+  Friction  bool          // find viscous and Coulomb friction
+  Params    *BaseParams
+  W         *mat.Dense    // stacked regressor
+  Tau       *mat.Dense    // stacked torques
+}
+
+// Set tree state from sample
+func (base *Link) setSample(mov []*Joint, qs map[string][]float64, s *DynSample) {
+  for i, jnt := range mov {
+    q := qs[jnt.Src.Name]
+    q[0], q[1], q[2] = s.Q[i], s.Qd[i], s.Qdd[i]
+  }
+  base.UpdateState(qs)
+}
+
+// Prepare identification problem for the samples
+func (base *Link) NewIdentification(samples []DynSample, g float64, fric bool) *Identification {
+  id := &Identification{Base: base, Friction: fric}
+  id.Params = base.FindBaseParams(g, fric)
+  mov := base.MovableJoints()
+  n := len(mov)
+  qs := MakeJointMap(mov)
+  cols := param_Body*len(base.DynLinks())
+  if fric {
+    cols += 2*n
+  }
+  id.W = mat.NewDense(n*len(samples), cols, nil)
+  id.Tau = mat.NewDense(n*len(samples), 1, nil)
+  for k := range samples {
+    base.setSample(mov, qs, &samples[k])
+    matInsert(k*n, 0, id.W, base.regressorWith(g, fric))
+    for i := 0; i < n; i++ {
+      id.Tau.Set(k*n+i, 0, samples[k].Tau[i])
+    }
+  }
+  return id
+}
+
+// Condition number of the base regressor
+func (id *Identification) Cond() float64 {
+  return mat.Cond(id.Params.Columns(id.W), 2)
+}
+
+// Base parameters with least squares
+func (id *Identification) LeastSquares() (*mat.Dense, error) {
+  var res mat.Dense
+  if err := res.Solve(id.Params.Columns(id.W), id.Tau); err != nil {
+    return nil, err
+  }
+  return &res, nil
+}
+
+// Difference between measured and predicted torques (RMS)
+func (id *Identification) Residual(pi *mat.Dense) float64 {
+  var e mat.Dense
+  e.Mul(id.W, pi)
+  e.Sub(&e, id.Tau)
+  r, _ := e.Dims()
+  return mat.Norm(&e, 2) / math.Sqrt(float64(r))
+}
+
+// Pseudo-inertia matrix [Sigma h; h^T m], Sigma = tr(Io)/2*E - Io
+func pseudoInertia(p []float64) *mat.SymDense {
+  tr := 0.5*(p[4] + p[7] + p[9])
+  return mat.NewSymDense(4, []float64{
+    tr-p[4],  -p[5],    -p[6],    p[1],
+    -p[5],    tr-p[7],  -p[8],    p[2],
+    -p[6],    -p[8],    tr-p[9],  p[3],
+    p[1],     p[2],     p[3],     p[0]})
+}
+
+// Parameters from log-Cholesky coordinates
+// [alpha, d1, d2, d3, s12, s23, s13, t1, t2, t3]
+func fromLogCholesky(th []float64) []float64 {
+  ea := math.Exp(th[0])
+  u := mat.NewDense(4,4, []float64{
+    math.Exp(th[1]), th[4], th[6], th[7],
+    0, math.Exp(th[2]), th[5], th[8],
+    0, 0, math.Exp(th[3]), th[9],
+    0, 0, 0, 1})
+  u.Scale(ea, u)
+  var j mat.Dense
+  j.Mul(u, u.T())
+  // Io = tr(Sigma)*E - Sigma
+  tr := j.At(0,0) + j.At(1,1) + j.At(2,2)
+  return []float64{j.At(3,3), j.At(0,3), j.At(1,3), j.At(2,3),
+    tr - j.At(0,0), -j.At(0,1), -j.At(0,2),
+    tr - j.At(1,1), -j.At(1,2), tr - j.At(2,2)}
+}
+
+// Log-Cholesky coordinates for parameters,
+// pseudo-inertia is regularized when it is not positive definite,
+// return error when the regularization fails (e.g. for NaN)
+func toLogCholesky(p []float64) ([]float64, error) {
+  j := pseudoInertia(p)
+  // J = U*U^T with upper U, use Cholesky of the reversed matrix
+  rev := mat.NewSymDense(4, nil)
+  var chol mat.Cholesky
+  ok := false
+  for it, eps := 0, 0.0; it < 30 && !ok; it, eps = it+1, math.Max(1E-9, 10*eps) {
+    for a := 0; a < 4; a++ {
+      for b := a; b < 4; b++ {
+        rev.SetSym(a, b, j.At(3-a, 3-b))
+      }
+      rev.SetSym(a, a, rev.At(a,a) + eps*math.Max(1, p[0]))
+    }
+    ok = chol.Factorize(rev)
+  }
+  if !ok {
+    return nil, errors.New("pseudo-inertia can not be regularized")
+  }
+  var l mat.TriDense
+  chol.LTo(&l)
+  u := func(a, b int) float64 { return l.At(3-a, 3-b) }
+  ea := u(3,3)
+  return []float64{math.Log(ea),
+    math.Log(u(0,0)/ea), math.Log(u(1,1)/ea), math.Log(u(2,2)/ea),
+    u(0,1)/ea, u(1,2)/ea, u(0,2)/ea,
+    u(0,3)/ea, u(1,3)/ea, u(2,3)/ea}, nil
+}
+
+// Physically consistent parameters with log-Cholesky parametrization,
+// the cost is |W*pi - tau|^2/N + lambda*|pi - pi0|^2
+// where pi0 is the current model, links without mass are not changed,
+// return full parameter vector (with friction if need)
+func (id *Identification) Consistent(lambda float64, iter int) (*mat.Dense, error) {
+  lnk := id.Base.DynLinks()
+  prior := id.Prior()
+  rows, cols := id.W.Dims()
+  nb := param_Body*len(lnk)
+  // free variables
+  var th []float64
+  var free []int     // indices of links
+  for i := range lnk {
+    p := mat.Col(nil, 0, prior.Slice(param_Body*i, param_Body*(i+1), 0, 1))
+    if p[0] > 0 {
+      lc, err := toLogCholesky(p)
+      if err != nil {
+        return nil, err
+      }
+      th = append(th, lc...)
+      free = append(free, i)
+    }
+  }
+  for c := nb; c < cols; c++ {
+    th = append(th, prior.At(c,0))
+  }
+  params := func(th []float64) *mat.Dense {
+    pi := mat.DenseCopyOf(prior)
+    for k, i := range free {
+      for j, v := range fromLogCholesky(th[param_Body*k : param_Body*(k+1)]) {
+        pi.Set(param_Body*i + j, 0, v)
+      }
+    }
+    for c := nb; c < cols; c++ {
+      pi.Set(c, 0, th[param_Body*len(free) + c - nb])
+    }
+    return pi
+  }
+  sw := 1 / math.Sqrt(float64(rows))
+  sl := math.Sqrt(lambda)
+  residual := func(pi *mat.Dense) *mat.Dense {
+    var e, d mat.Dense
+    e.Mul(id.W, pi)
+    e.Sub(&e, id.Tau)
+    e.Scale(sw, &e)
+    d.Sub(pi, prior)
+    d.Scale(sl, &d)
+    var res mat.Dense
+    res.Stack(&e, &d)
+    return &res
+  }
+  // Levenberg-Marquardt
+  var ws, wl mat.Dense
+  ws.Scale(sw, id.W)
+  eye := mat.NewDense(cols, cols, nil)
+  for i := 0; i < cols; i++ {
+    eye.Set(i, i, sl)
+  }
+  wl.Stack(&ws, eye)
+  pi := params(th)
+  r := residual(pi)
+  cost := mat.Dot(r.ColView(0), r.ColView(0))
+  mu := 1E-3
+  nt := len(th)
+  for it := 0; it < iter; it++ {
+    // d pi / d theta
+    dp := mat.NewDense(cols, nt, nil)
+    for k := 0; k < nt; k++ {
+      h := 1E-6 * math.Max(1, math.Abs(th[k]))
+      th[k] += h
+      pp := params(th)
+      th[k] -= 2*h
+      pm := params(th)
+      th[k] += h
+      pp.Sub(pp, pm)
+      pp.Scale(0.5/h, pp)
+      dp.SetCol(k, mat.Col(nil, 0, pp))
+    }
+    var jac, jtj, jtr, step mat.Dense
+    jac.Mul(&wl, dp)
+    jtj.Mul(jac.T(), &jac)
+    jtr.Mul(jac.T(), r)
+    improved := false
+    for !improved && mu < 1E10 {
+      a := mat.DenseCopyOf(&jtj)
+      for k := 0; k < nt; k++ {
+        a.Set(k, k, a.At(k,k)*(1+mu) + 1E-12)
+      }
+      if err := step.Solve(a, &jtr); err != nil {
+        mu *= 10
+        continue
+      }
+      tn := make([]float64, nt)
+      for k := range tn {
+        tn[k] = th[k] - step.At(k,0)
+      }
+      pn := params(tn)
+      rn := residual(pn)
+      if cn := mat.Dot(rn.ColView(0), rn.ColView(0)); cn < cost {
+        improved = true
+        th, pi, r = tn, pn, rn
+        if cost - cn < 1E-12*cost {
+          return pi, nil
+        }
+        cost = cn
+        mu = math.Max(mu/10, 1E-9)
+      } else {
+        mu *= 10
+      }
+    }
+    if !improved {
+      break
+    }
+  }
+  if math.IsNaN(cost) {
+    return nil, errors.New("identification diverged")
+  }
+  return pi, nil
+}
+
+// Parameters of the current model (with friction if need)
+func (id *Identification) Prior() *mat.Dense {
+  pi := id.Base.Parameters()
+  if !id.Friction {
+    return pi
+  }
+  mov := id.Base.MovableJoints()
+  fr := mat.NewDense(2*len(mov), 1, nil)
+  for i, jnt := range mov {
+    fr.Set(2*i, 0, jnt.Fric.Viscous)
+    fr.Set(2*i+1, 0, jnt.Fric.Coulomb)
+  }
+  var res mat.Dense
+  res.Stack(pi, fr)
+  return &res
+}
+
+// Write identified parameters (full vector) into the tree,
+// friction coefficients are updated if need
+func (id *Identification) Apply(pi *mat.Dense) {
+  nb := param_Body*len(id.Base.DynLinks())
+  var body mat.Dense
+  body.CloneFrom(pi.Slice(0, nb, 0, 1))
+  id.Base.SetParameters(&body)
+  if id.Friction {
+    for i, jnt := range id.Base.MovableJoints() {
+      jnt.Fric.Viscous = pi.At(nb+2*i, 0)
+      jnt.Fric.Coulomb = pi.At(nb+2*i+1, 0)
+    }
+  }
+}
+
+// Identify payload of the tool when the robot model is known,
+// result is saved into tool parameters
+func (base *Link) IdentifyPayload(tool *Tool, samples []DynSample, g float64) error {
+  mov := base.MovableJoints()
+  n := len(mov)
+  qs := MakeJointMap(mov)
+  lnk := base.DynLinks()
+  col := -1
+  for i, v := range lnk {
+    if v == tool.Link {
+      col = param_Body*i
+    }
+  }
+  if col < 0 {
+    return errors.New("tool is not attached")
+  }
+  // robot without payload
+  prev := tool.Dyn.M
+  tool.Dyn.M = 0
+  w := mat.NewDense(n*len(samples), param_Body, nil)
+  rhs := mat.NewDense(n*len(samples), 1, nil)
+  for k := range samples {
+    base.setSample(mov, qs, &samples[k])
+    matInsert(k*n, 0, w, base.Regressor(g).Slice(0, n, col, col+param_Body))
+    base.UpdateDyn(g)
+    for i, jnt := range mov {
+      rhs.Set(k*n+i, 0, samples[k].Tau[i] - jnt.Tau)
+    }
+  }
+  tool.Dyn.M = prev
+  var p mat.Dense
+  if err := p.Solve(w, rhs); err != nil {
+    return err
+  }
+  // from link to tool frame
+  d := InertialFromParams(mat.Col(nil, 0, &p))
+  fr := &tool.Frame
+  d.Rc.Sub(d.Rc, fr.Pos)
+  d.Rc.Mul(fr.Rot.T(), d.Rc)
+  d.I.Mul(fr.Rot.T(), d.I)
+  d.I.Mul(d.I, fr.Rot)
+  tool.Dyn = d
+  return nil
+}
+
+// Copy inertial parameters of links into the URDF model
+func (base *Link) UpdateModel() {
+  base.forEach(func (v *Link) {
+    d := &v.Dyn
+    v.Src.SetInertial(d.M, mat.Col(nil, 0, d.Rc), []float64{
+      d.I.At(0,0), d.I.At(0,1), d.I.At(0,2), d.I.At(1,1), d.I.At(1,2), d.I.At(2,2)})
+  })
+}
@@ -5,6 +5,52 @@ import (
   "math"
 )
 
+// Common interface of analytical IK solvers,
+// each solution is saved in separate column of matrix 
+type IkSolver interface {
+  IkFull(rot, pos *mat.Dense)                   // find all solutions 
+  Closest(prev []float64) int                   // index of the closest solution 
+  ClosestTo(qs map[string][]float64) int        // same for the joint map
+  SetTo(qs map[string][]float64, col int)       // save solution 
+  Solutions() *mat.Dense                        // matrix of solutions 
+  Joints() []string                             // joint names 
+}
+
+// Find column with minimal L1 distance to the previous state
+// return -1 if solution not found
+func closestColumn(q *mat.Dense, prev []float64) int {
+  rows, cols := q.Dims()
+  col := -1 
+  minimal := math.Inf(1) 
+  for c := 0; c < cols; c++ {
+    diff := 0.0
+    for r := 0; r < rows; r++ {
+      v := q.At(r,c)
+      if math.IsNaN(v) {
+        diff = math.Inf(1)
+        break 
+      }
+      diff += math.Abs(v-prev[r])
+    }
+    if diff < minimal {
+      minimal = diff
+      col = c
+    }
+  }
+  return col 
+}
+
+// Wrap angle into (-pi, pi]
+func wrapAngle(q float64) float64 {
+  q = math.Mod(q, 2*math.Pi)
+  if q > math.Pi {
+    q -= 2*math.Pi
+  } else if q <= -math.Pi {
+    q += 2*math.Pi
+  }
+  return q
+}
+
 type Ik6_Geometry struct {
   A  [3]float64
   B  float64
@@ -138,27 +184,7 @@ func (par *Ik6_Geometry) Closest(prev []float64) int {
   if prev == nil {
     prev = []float64{0,0,0,0,0,0} 
   }
-  // find closest colution
-  col := -1 
-  minimal := math.Inf(1) 
-  for c := 0; c < 8; c++ {     // at most 8 solutions
-    diff := 0.0
-    for r := 0; r < 6; r++ {   // for 6 joint robot only
-      q := par.Q.At(r,c)
-      if math.IsNaN(q) {
-        diff = math.Inf(1)
-        continue 
-      }
-      // find maximal distance
-      diff += math.Abs(q-prev[r])
-    }
-    // find minimal among maximum
-    if diff < minimal {
-      minimal = diff
-      col = c
-    }
-  }
-  return col 
+  return closestColumn(par.Q, prev)
 }
 
 // Find closest solution based on joint map 
@@ -177,6 +203,16 @@ func (par *Ik6_Geometry) SetTo(qs map[string][]float64, col int) {
   }
 }
 
+// Get matrix of solutions 
+func (par *Ik6_Geometry) Solutions() *mat.Dense {
+  return par.Q
+}
+
+// Get joint names 
+func (par *Ik6_Geometry) Joints() []string {
+  return par.Name[:]
+}
+
 // Find parameters if the robot IK can be calculated
 // via analytical solution for 6 joints 
 func (base *Link) FindIk6Param(ee *Link) *Ik6_Geometry {
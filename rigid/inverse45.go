@@ -0,0 +1,425 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Common part of the solvers for 4 and 5 joint robots
+type ikSolution struct {
+  Name  []string     // joint names
+  Q     *mat.Dense   // matrix of solutions, each solution in separate column
+}
+
+// Find closest solution for the previous state
+// return -1 if solution not found
+func (par *ikSolution) Closest(prev []float64) int {
+  if prev == nil {
+    prev = make([]float64, len(par.Name))
+  }
+  return closestColumn(par.Q, prev)
+}
+
+// Find closest solution based on joint map
+func (par *ikSolution) ClosestTo(qs map[string][]float64) int {
+  prev := make([]float64, len(par.Name))
+  for i,nm := range par.Name {
+    prev[i] = qs[nm][0]
+  }
+  return par.Closest(prev)
+}
+
+// Save solution into joint map
+func (par *ikSolution) SetTo(qs map[string][]float64, col int) {
+  for i,nm := range par.Name {
+    qs[nm][0] = par.Q.At(i,col)
+  }
+}
+
+// Get matrix of solutions
+func (par *ikSolution) Solutions() *mat.Dense {
+  return par.Q
+}
+
+// Get joint names
+func (par *ikSolution) Joints() []string {
+  return par.Name
+}
+
+// Prepare names and solution matrix
+func (par *ikSolution) init(mov []*Joint, cols int) {
+  par.Name = make([]string, len(mov))
+  for i, jnt := range mov {
+    par.Name[i] = jnt.Src.Name
+  }
+  par.Q = mat.NewDense(len(mov), cols, nil)
+}
+
+// Set column of solutions to NaN
+func (par *ikSolution) reject(col int) {
+  rows,_ := par.Q.Dims()
+  for r := 0; r < rows; r++ {
+    par.Q.Set(r, col, math.NaN())
+  }
+}
+
+// Update chain for zero joint angles
+func (base *Link) zeroState(mov []*Joint) {
+  qs := MakeJointMap(mov)
+  base.UpdateState(qs)
+}
+
+// Compare joint axis with the given direction in base frame,
+// return 1 or -1 when they are parallel and 0 otherwise
+func (jnt *Joint) axisSign(x, y, z float64) float64 {
+  var ax mat.Dense
+  ax.Mul(jnt.Child.State.Rot, jnt.Axis)
+  d := ax.At(0,0)*x + ax.At(1,0)*y + ax.At(2,0)*z
+  if d > 1-1E-6 {
+    return 1
+  } else if d < -1+1E-6 {
+    return -1
+  }
+  return 0
+}
+
+func (jnt *Joint) isRevolute() bool {
+  return jnt.Type >= joint_Rx && jnt.Type <= joint_Rz
+}
+
+func (jnt *Joint) isPrismatic() bool {
+  return jnt.Type <= joint_Tz
+}
+
+// Rotation about base Z axis from the zero orientation r0t (transposed)
+func yawFrom(rot, r0t *mat.Dense) float64 {
+  var d mat.Dense
+  d.Mul(rot, r0t)
+  return math.Atan2(d.At(1,0), d.At(0,0))
+}
+
+// Planar manipulator with 2 links,
+// return angle of the first link and relative angle of the second one
+func planar2(x, y, l1, l2, elbow float64) (float64, float64, bool) {
+  c := (x*x + y*y - l1*l1 - l2*l2) / (2*l1*l2)
+  if c > 1 || c < -1 {
+    return 0, 0, false
+  }
+  g := elbow * math.Acos(c)
+  s, c := math.Sincos(g)
+  return math.Atan2(y, x) - math.Atan2(l2*s, l1+l2*c), g, true
+}
+
+// SCARA robot with RRPR joints,
+// all the axes are parallel to Z of the base frame
+type Ik4_Scara struct {
+  ikSolution
+  L    [2]float64   // lengths of the arms in XY plane
+  Phi  [2]float64   // arm directions for zero joint angles
+  S    [4]float64   // axis directions w.r.t. the base Z
+  P0   [3]float64   // position of the first axis and height of the wrist
+  Tool *mat.Dense   // wrist to end effector in the end effector frame
+  R    *mat.Dense   // initial rotation (transposed)
+}
+
+// Inverse kinematics
+// Return matrix with 2 solutions (left and right arm)
+func (par *Ik4_Scara) IkFull(rot, pos *mat.Dense) {
+  // wrist position
+  var w mat.Dense
+  w.Mul(rot, par.Tool)
+  w.Sub(pos, &w)
+  yaw := yawFrom(rot, par.R)
+  x, y := w.At(0,0)-par.P0[0], w.At(1,0)-par.P0[1]
+  d := par.S[2] * (w.At(2,0)-par.P0[2])
+
+  for col, elbow := range []float64{1, -1} {
+    t1, g, ok := planar2(x, y, par.L[0], par.L[1], elbow)
+    if !ok {
+      par.reject(col)
+      continue
+    }
+    par.Q.Set(0, col, wrapAngle(par.S[0]*(t1-par.Phi[0])))
+    par.Q.Set(1, col, wrapAngle(par.S[1]*(g-par.Phi[1]+par.Phi[0])))
+    par.Q.Set(2, col, d)
+    par.Q.Set(3, col, wrapAngle(par.S[3]*(yaw-t1-g+par.Phi[1])))
+  }
+}
+
+// Find parameters of SCARA robot,
+// return nil if the chain has another structure
+func (base *Link) FindIkScaraParam(ee *Link) *Ik4_Scara {
+  mov := ee.Predecessors()
+  if len(mov) != 4 || !mov[0].isRevolute() || !mov[1].isRevolute() ||
+     !mov[2].isPrismatic() || !mov[3].isRevolute() {
+    return nil
+  }
+  base.zeroState(mov)
+  var par Ik4_Scara
+  for i, jnt := range mov {
+    par.S[i] = jnt.axisSign(0,0,1)
+    if par.S[i] == 0 {
+      return nil
+    }
+  }
+  p1, p2 := mov[0].Child.State.Pos, mov[1].Child.State.Pos
+  p4 := mov[3].Child.State.Pos
+  dx, dy := p2.At(0,0)-p1.At(0,0), p2.At(1,0)-p1.At(1,0)
+  par.L[0], par.Phi[0] = math.Hypot(dx,dy), math.Atan2(dy,dx)
+  dx, dy = p4.At(0,0)-p2.At(0,0), p4.At(1,0)-p2.At(1,0)
+  par.L[1], par.Phi[1] = math.Hypot(dx,dy), math.Atan2(dy,dx)
+  par.P0 = [3]float64{p1.At(0,0), p1.At(1,0), p4.At(2,0)}
+
+  par.R = mat.DenseCopyOf(ee.State.Rot.T())
+  par.Tool = zero31()
+  par.Tool.Sub(ee.State.Pos, p4)
+  par.Tool.Mul(par.R, par.Tool)
+
+  par.init(mov, 2)
+  return &par
+}
+
+// Palletizer with 4 actuated joints (Z-Y-Y-Z)
+// and passive joint which keeps the flange orientation (parallelogram),
+// the arm is expected in XZ plane for zero joint angles
+type Ik4_Palletizer struct {
+  ikSolution
+  L     [2]float64   // lengths of the upper arm and forearm
+  Alpha [2]float64   // initial directions of the upper arm and forearm
+  S     [5]float64   // axis directions w.r.t. base Z or Y
+  B     float64      // lateral offset of the flange axis
+  P0    [2]float64   // position of the first axis
+  P2    [2]float64   // shoulder position w.r.t. the first axis (radius, height)
+  D     [2]float64   // flange axis w.r.t. passive joint (radius, height)
+  Tool  *mat.Dense   // flange to end effector in the end effector frame
+  R     *mat.Dense   // initial rotation (transposed)
+}
+
+// Inverse kinematics
+// Return matrix with 4 solutions,
+// passive joint value is in row 3
+func (par *Ik4_Palletizer) IkFull(rot, pos *mat.Dense) {
+  var w mat.Dense
+  w.Mul(rot, par.Tool)
+  w.Sub(pos, &w)
+  yaw := yawFrom(rot, par.R)
+  cx, cy := w.At(0,0)-par.P0[0], w.At(1,0)-par.P0[1]
+  rho := math.Sqrt(cx*cx + cy*cy - par.B*par.B)
+
+  col := 0
+  for _, r := range []float64{rho, -rho} {
+    q1 := math.Atan2(cy, cx) - math.Atan2(par.B, r)
+    x := r - par.D[0] - par.P2[0]
+    y := w.At(2,0) - par.D[1] - par.P2[1]
+    for _, elbow := range []float64{1, -1} {
+      ta, g, ok := planar2(x, y, par.L[0], par.L[1], elbow)
+      if !ok || math.IsNaN(rho) {
+        par.reject(col)
+        col++
+        continue
+      }
+      // absolute angle decreases for positive rotation around Y
+      q2 := par.Alpha[0] - ta
+      q3 := par.Alpha[1] - par.Alpha[0] - g
+      par.Q.Set(0, col, wrapAngle(par.S[0]*q1))
+      par.Q.Set(1, col, wrapAngle(par.S[1]*q2))
+      par.Q.Set(2, col, wrapAngle(par.S[2]*q3))
+      par.Q.Set(3, col, wrapAngle(-par.S[3]*(q2+q3)))
+      par.Q.Set(4, col, wrapAngle(par.S[4]*(yaw-q1)))
+      col++
+    }
+  }
+}
+
+// Find parameters of palletizer,
+// return nil if the chain has another structure
+func (base *Link) FindIkPalletParam(ee *Link) *Ik4_Palletizer {
+  mov := ee.Predecessors()
+  if len(mov) != 5 {
+    return nil
+  }
+  for _, jnt := range mov {
+    if !jnt.isRevolute() {
+      return nil
+    }
+  }
+  base.zeroState(mov)
+  var par Ik4_Palletizer
+  for i, jnt := range mov {
+    if i == 0 || i == 4 {
+      par.S[i] = jnt.axisSign(0,0,1)
+    } else {
+      par.S[i] = jnt.axisSign(0,1,0)
+    }
+    if par.S[i] == 0 {
+      return nil
+    }
+  }
+  p1, p2, p3 := mov[0].Child.State.Pos, mov[1].Child.State.Pos, mov[2].Child.State.Pos
+  pw, p4 := mov[3].Child.State.Pos, mov[4].Child.State.Pos
+  par.P0 = [2]float64{p1.At(0,0), p1.At(1,0)}
+  par.P2 = [2]float64{p2.At(0,0)-p1.At(0,0), p2.At(2,0)}
+  par.B = p4.At(1,0) - p1.At(1,0)
+  par.D = [2]float64{p4.At(0,0)-pw.At(0,0), p4.At(2,0)-pw.At(2,0)}
+  dx, dz := p3.At(0,0)-p2.At(0,0), p3.At(2,0)-p2.At(2,0)
+  par.L[0], par.Alpha[0] = math.Hypot(dx,dz), math.Atan2(dz,dx)
+  dx, dz = pw.At(0,0)-p3.At(0,0), pw.At(2,0)-p3.At(2,0)
+  par.L[1], par.Alpha[1] = math.Hypot(dx,dz), math.Atan2(dz,dx)
+
+  par.R = mat.DenseCopyOf(ee.State.Rot.T())
+  par.Tool = zero31()
+  par.Tool.Sub(ee.State.Pos, p4)
+  par.Tool.Mul(par.R, par.Tool)
+
+  par.init(mov, 4)
+  return &par
+}
+
+// Robot with 5 joints (Z-Y-Y-Y and roll),
+// the roll axis defines the tool direction,
+// the arm is expected in XZ plane for zero joint angles
+type Ik5_Geometry struct {
+  ikSolution
+  L     [2]float64   // lengths of the upper arm and forearm
+  Alpha [3]float64   // initial directions of the upper arm, forearm and tool
+  S     [4]float64   // axis directions w.r.t. base Z or Y
+  B     float64      // lateral offset of the wrist
+  P0    [2]float64   // position of the first axis
+  P2    [2]float64   // shoulder position w.r.t. the first axis (radius, height)
+  U     *mat.Dense   // roll axis in the end effector frame
+  Tool  *mat.Dense   // wrist to end effector in the end effector frame
+  R     *mat.Dense   // initial rotation (transposed)
+}
+
+// Inverse kinematics for position and orientation,
+// the tool axis is projected into the arm plane
+// Return matrix with 4 solutions
+func (par *Ik5_Geometry) IkFull(rot, pos *mat.Dense) {
+  var w, u mat.Dense
+  w.Mul(rot, par.Tool)
+  w.Sub(pos, &w)
+  u.Mul(rot, par.U)
+  par.solve(&w, &u, rot)
+}
+
+// Inverse kinematics for position and tool axis,
+// the end effector is expected on the roll axis
+func (par *Ik5_Geometry) IkAxis(axis, pos *mat.Dense) {
+  var w, u mat.Dense
+  u.Scale(1/mat.Norm(axis,2), axis)
+  w.Scale(mat.Dot(par.Tool.ColView(0), par.U.ColView(0)), &u)
+  w.Sub(pos, &w)
+  par.solve(&w, &u, nil)
+}
+
+// Find joint angles for the wrist point and tool axis,
+// calculate roll when rotation is defined
+func (par *Ik5_Geometry) solve(w, u, rot *mat.Dense) {
+  cx, cy := w.At(0,0)-par.P0[0], w.At(1,0)-par.P0[1]
+  rho := math.Sqrt(cx*cx + cy*cy - par.B*par.B)
+
+  col := 0
+  for _, r := range []float64{rho, -rho} {
+    q1 := math.Atan2(cy, cx) - math.Atan2(par.B, r)
+    // tool axis in the arm plane
+    s1, c1 := math.Sincos(q1)
+    ux := c1*u.At(0,0) + s1*u.At(1,0)
+    phi := math.Atan2(u.At(2,0), ux)
+    x := r - par.P2[0]
+    y := w.At(2,0) - par.P2[1]
+    for _, elbow := range []float64{1, -1} {
+      ta, g, ok := planar2(x, y, par.L[0], par.L[1], elbow)
+      if !ok || math.IsNaN(rho) {
+        par.reject(col)
+        col++
+        continue
+      }
+      q2 := par.Alpha[0] - ta
+      q3 := par.Alpha[1] - par.Alpha[0] - g
+      q4 := par.Alpha[2] - par.Alpha[1] + ta + g - phi
+      par.Q.Set(0, col, wrapAngle(par.S[0]*q1))
+      par.Q.Set(1, col, wrapAngle(par.S[1]*q2))
+      par.Q.Set(2, col, wrapAngle(par.S[2]*q3))
+      par.Q.Set(3, col, wrapAngle(par.S[3]*q4))
+      q5 := 0.0
+      if rot != nil {
+        q5 = par.roll(rot, q1, q2+q3+q4)
+      }
+      par.Q.Set(4, col, q5)
+      col++
+    }
+  }
+}
+
+// Find rotation around the tool axis
+func (par *Ik5_Geometry) roll(rot *mat.Dense, q1, pitch float64) float64 {
+  var m mat.Dense
+  m.Mul(Rz(q1), Ry(pitch))
+  m.Mul(m.T(), rot)
+  m.Mul(par.R, &m)
+  // rotation around U
+  s := 0.5*((m.At(2,1)-m.At(1,2))*par.U.At(0,0) +
+            (m.At(0,2)-m.At(2,0))*par.U.At(1,0) +
+            (m.At(1,0)-m.At(0,1))*par.U.At(2,0))
+  c := 0.5*(m.At(0,0) + m.At(1,1) + m.At(2,2) - 1)
+  return math.Atan2(s, c)
+}
+
+// Find parameters of 5 joint robot,
+// return nil if the chain has another structure
+func (base *Link) FindIk5Param(ee *Link) *Ik5_Geometry {
+  mov := ee.Predecessors()
+  if len(mov) != 5 {
+    return nil
+  }
+  for _, jnt := range mov {
+    if !jnt.isRevolute() {
+      return nil
+    }
+  }
+  base.zeroState(mov)
+  var par Ik5_Geometry
+  par.S[0] = mov[0].axisSign(0,0,1)
+  for i := 1; i < 4; i++ {
+    par.S[i] = mov[i].axisSign(0,1,0)
+  }
+  for _, s := range par.S {
+    if s == 0 {
+      return nil
+    }
+  }
+  // roll axis in the XZ plane
+  par.U = zero31()
+  par.U.Mul(mov[4].Child.State.Rot, mov[4].Axis)
+  if math.Abs(par.U.At(1,0)) > 1E-6 {
+    return nil
+  }
+  p1, p2, p3 := mov[0].Child.State.Pos, mov[1].Child.State.Pos, mov[2].Child.State.Pos
+  p4, p5 := mov[3].Child.State.Pos, mov[4].Child.State.Pos
+  // wrist point is the intersection of the pitch and roll axes
+  w := Txyz(p4.At(0,0), p5.At(1,0), p4.At(2,0))
+  var d mat.Dense
+  d.Sub(p4, p5)
+  if math.Abs(d.At(0,0)*par.U.At(2,0) - d.At(2,0)*par.U.At(0,0)) > 1E-6 {
+    // roll axis does not cross pitch axis
+    return nil
+  }
+  par.P0 = [2]float64{p1.At(0,0), p1.At(1,0)}
+  par.P2 = [2]float64{p2.At(0,0)-p1.At(0,0), p2.At(2,0)}
+  par.B = w.At(1,0) - p1.At(1,0)
+  dx, dz := p3.At(0,0)-p2.At(0,0), p3.At(2,0)-p2.At(2,0)
+  par.L[0], par.Alpha[0] = math.Hypot(dx,dz), math.Atan2(dz,dx)
+  dx, dz = p4.At(0,0)-p3.At(0,0), p4.At(2,0)-p3.At(2,0)
+  par.L[1], par.Alpha[1] = math.Hypot(dx,dz), math.Atan2(dz,dx)
+  par.Alpha[2] = math.Atan2(par.U.At(2,0), par.U.At(0,0))
+
+  par.R = mat.DenseCopyOf(ee.State.Rot.T())
+  par.Tool = zero31()
+  par.Tool.Sub(ee.State.Pos, w)
+  par.Tool.Mul(par.R, par.Tool)
+  // keep the axis in the end effector frame
+  par.U.Mul(par.R, par.U)
+
+  par.init(mov, 4)
+  return &par
+}
@@ -179,7 +179,19 @@ func toAA(m *mat.Dense) (float64,[]float64,bool) {
   sin := math.Sin(theta)
   // theta is +- PI
   if math.Abs(sin) < 1E-10 {
-    return theta, []float64{math.Sqrt(0.5*(r11+1)),math.Sqrt(0.5*(r22+1)),math.Sqrt(0.5*(r33+1))}, true
+    r := []float64{math.Sqrt(0.5*(r11+1)),math.Sqrt(0.5*(r22+1)),math.Sqrt(0.5*(r33+1))}
+    // signs w.r.t. the largest component
+    if r[0] >= r[1] && r[0] >= r[2] {
+      r[1] = math.Copysign(r[1], m.At(0,1)+m.At(1,0))
+      r[2] = math.Copysign(r[2], m.At(0,2)+m.At(2,0))
+    } else if r[1] >= r[2] {
+      r[0] = math.Copysign(r[0], m.At(0,1)+m.At(1,0))
+      r[2] = math.Copysign(r[2], m.At(1,2)+m.At(2,1))
+    } else {
+      r[0] = math.Copysign(r[0], m.At(0,2)+m.At(2,0))
+      r[1] = math.Copysign(r[1], m.At(1,2)+m.At(2,1))
+    }
+    return theta, r, true
   }
   // general case
   sin *= 2
@@ -188,7 +200,7 @@ func toAA(m *mat.Dense) (float64,[]float64,bool) {
 
 func fromAA(theta float64, r []float64) *mat.Dense {
   if r == nil {
-    return mat.NewDense(4,4, []float64 {
+    return mat.NewDense(3,3, []float64 {
       1,0,0,
       0,1,0,
       0,0,1})
@@ -197,12 +209,28 @@ func fromAA(theta float64, r []float64) *mat.Dense {
   c1 := 1-c
   rx,ry,rz := r[0],r[1],r[2]
 
-  return mat.NewDense(4,4, []float64 {
+  return mat.NewDense(3,3, []float64 {
     rx*rx*c1+c, rx*ry*c1-rz*s, rx*rz*c1+ry*s,
     rx*ry*c1+rz*s, ry*ry*c1+c, ry*rz*c1-rx*s,
     rx*rz*c1-ry*s, ry*rz*c1+rx*s, rz*rz*c1+c})
 }
 
+// Interpolate transformation between t0 (s = 0) and t1 (s = 1),
+// rotation is found with axis-angle representation
+func Interpolate(t0, t1 *Transform, s float64) *Transform {
+  var res Transform
+  res.Pos = zero31()
+  res.Pos.Sub(t1.Pos, t0.Pos)
+  res.Pos.Scale(s, res.Pos)
+  res.Pos.Add(res.Pos, t0.Pos)
+  var d mat.Dense
+  d.Mul(t0.Rot.T(), t1.Rot)
+  theta, r, _ := toAA(&d)
+  res.Rot = eye33()
+  res.Rot.Mul(t0.Rot, fromAA(s*theta, r))
+  return &res
+}
+
 
 
 func jacEmpty(cols int) *mat.Dense {
@@ -216,24 +244,15 @@ func MatPrint(m mat.Matrix) {
 
 type Ode func(float64,*mat.Dense) *mat.Dense 
 
+// Fixed step RK4 from t0 to tn, the last step is shortened if need
 func OdeSolver(fn Ode, t0 float64, x0 *mat.Dense, step, tn float64) *mat.Dense {
-  var xn, tmp mat.Dense 
-  xn.Scale(1, x0)  
-  step2 := 0.5 * step 
-  for t := t0+step; t <= tn; t += step {
-    // coefficients
-    k1 := fn(t,&xn) 
-    tmp.Scale(step2, k1); tmp.Add(&tmp, &xn)
-    k2 := fn(t+step2, &tmp)
-    tmp.Scale(step2, k2); tmp.Add(&tmp, &xn) 
-    k3 := fn(t+step2, &tmp) 
-    tmp.Scale(step, k3); tmp.Add(&tmp, &xn)
-    k4 := fn(t+step, &tmp)
-    // find result
-    tmp.Scale(step/6, k1); xn.Add(&xn, &tmp)
-    tmp.Scale(step/3, k2); xn.Add(&xn, &tmp)
-    tmp.Scale(step/3, k3); xn.Add(&xn, &tmp)
-    tmp.Scale(step/6, k4); xn.Add(&xn, &tmp) 
+  xn := mat.DenseCopyOf(x0)
+  n := int(math.Floor((tn - t0) / step + 1E-9))
+  for i := 0; i < n; i++ {
+    xn = Rk4Step(fn, t0 + float64(i)*step, xn, step)
+  }
+  if rest := tn - (t0 + float64(n)*step); rest > 1E-9*step {
+    xn = Rk4Step(fn, tn - rest, xn, rest)
   }
-  return &xn 
+  return xn 
 } 
\ No newline at end of file
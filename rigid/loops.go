@@ -0,0 +1,383 @@
+package rigid
+
+import (
+  "../urdf"
+  "errors"
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Kind of loop closing joint
+type LoopType int
+const (
+  Loop_Revolute LoopType = iota    // common point and z axis, 5 constraints
+  Loop_Spherical                   // common point, 3 constraints
+  Loop_Fixed                       // common frame, 6 constraints
+)
+
+// Passive joint which closes kinematic loop:
+// FrameA on link A must coincide with FrameB on link B
+type Loop struct {
+  Src     *urdf.Loop     // nil when defined in code
+  Name    string
+  Type    LoopType
+  A, B    *Link
+  FrameA  Transform      // in link A coordinates
+  FrameB  Transform      // in link B coordinates
+}
+
+// Newton projection parameters
+const (
+  loopTol  = 1E-10
+  loopIter = 50
+)
+
+// Orientation constraint s*(a.b) = 0, a and b are axes (columns) of frames A and B
+type loopAxes struct {
+  s     float64
+  a, b  int
+}
+
+// Orientation constraints, their rates are approximately the relative
+// angular velocity about x, y and z of the frame A
+var loopRot = map[LoopType][]loopAxes{
+  Loop_Revolute: {{-1, 1, 2}, {1, 0, 2}},
+  Loop_Fixed:    {{-1, 1, 2}, {1, 0, 2}, {-1, 0, 1}},
+}
+
+// Loop constructor based on URDF, return false for unknown type or links
+func loopFromModel(m *urdf.Loop, links map[string]*Link) (*Loop, bool) {
+  l := &Loop{Src: m, Name: m.Name}
+  switch m.Type {
+  case "revolute", "continuous":
+    l.Type = Loop_Revolute
+  case "spherical", "ball":
+    l.Type = Loop_Spherical
+  case "fixed":
+    l.Type = Loop_Fixed
+  default:
+    return nil, false
+  }
+  var ok bool
+  if l.A, ok = links[m.Parent.Name]; !ok {
+    return nil, false
+  }
+  if l.B, ok = links[m.Child.Name]; !ok {
+    return nil, false
+  }
+  l.FrameA = makeTransform(m.Parent.GetXyz(), m.Parent.GetRpy())
+  l.FrameB = makeTransform(m.Child.GetXyz(), m.Child.GetRpy())
+  return l, true
+}
+
+// Close loop between links a and b, frames are defined with position and RPY angles
+func (base *Link) AddLoop(name string, tp LoopType, a *Link, xyzA, rpyA []float64, b *Link, xyzB, rpyB []float64) *Loop {
+  l := &Loop{Name: name, Type: tp, A: a, B: b}
+  l.FrameA = makeTransform(xyzA, rpyA)
+  l.FrameB = makeTransform(xyzB, rpyB)
+  base.Loops = append(base.Loops, l)
+  return l
+}
+
+// Copy for the tree with the same links
+func (l *Loop) copyFor(base *Link) *Loop {
+  res := *l
+  base.forEach(func (v *Link) {
+    if v.Src == l.A.Src {
+      res.A = v
+    }
+    if v.Src == l.B.Src {
+      res.B = v
+    }
+  })
+  return &res
+}
+
+// Number of constraints
+func (l *Loop) Size() int {
+  return 3 + len(loopRot[l.Type])
+}
+
+// Number of constraints of all loops
+func (base *Link) loopSize() int {
+  res := 0
+  for _, l := range base.Loops {
+    res += l.Size()
+  }
+  return res
+}
+
+// Current loop frames in base
+func (l *Loop) frames() (Transform, Transform) {
+  var fa, fb Transform
+  fa.Reset()
+  fa.Set(&l.A.State)
+  fa.Apply(&l.FrameA)
+  fb.Reset()
+  fb.Set(&l.B.State)
+  fb.Apply(&l.FrameB)
+  return fa, fb
+}
+
+// Constraint violation for the current state, position (B - A)
+// and orientation errors of loops, nil when there are no loops
+func (base *Link) LoopError() *mat.Dense {
+  m := base.loopSize()
+  if m == 0 {
+    return nil
+  }
+  res := mat.NewDense(m, 1, nil)
+  r := 0
+  for _, l := range base.Loops {
+    fa, fb := l.frames()
+    for k := 0; k < 3; k++ {
+      res.Set(r+k, 0, fb.Pos.At(k,0) - fa.Pos.At(k,0))
+    }
+    r += 3
+    for _, ax := range loopRot[l.Type] {
+      res.Set(r, 0, ax.s * mat.Dot(fa.Rot.ColView(ax.a), fb.Rot.ColView(ax.b)))
+      r++
+    }
+  }
+  return res
+}
+
+// Jacobian of loop constraints (m x n), use all movable joints when mov is nil,
+// nil when there are no loops
+func (base *Link) LoopJacobian(mov []*Joint) *mat.Dense {
+  if mov == nil {
+    mov = base.MovableJoints()
+  }
+  jac, _ := base.loopJacobian(mov, false)
+  return jac
+}
+
+// Spatial velocities and accelerations of links for zero joint accelerations
+func (base *Link) biasMotion() (map[*Link]*mat.Dense, map[*Link]*mat.Dense) {
+  mov := base.MovableJoints()
+  acc := make([]float64, len(mov))
+  for i, jnt := range mov {
+    acc[i], jnt.Acc = jnt.Acc, 0
+  }
+  vel, ac := make(map[*Link]*mat.Dense), make(map[*Link]*mat.Dense)
+  vel[base], ac[base] = mat.NewDense(6,1,nil), mat.NewDense(6,1,nil)
+  base.motion(vel[base], ac[base], vel, ac)
+  for i, jnt := range mov {
+    jnt.Acc = acc[i]
+  }
+  return vel, ac
+}
+
+// Angular velocity, angular acceleration and linear acceleration
+// of the point p (base frame) attached to the link, all in base frame
+func pointMotion(v *Link, p *mat.Dense, vel, acc map[*Link]*mat.Dense) (*mat.Dense, *mat.Dense, *mat.Dense) {
+  rot := v.State.Rot
+  var r, vp mat.Dense
+  r.Sub(p, v.State.Pos)
+  r.Mul(rot.T(), &r)
+  w, vo := vel[v].Slice(0,3,0,1), vel[v].Slice(3,6,0,1)
+  dw, dvo := acc[v].Slice(0,3,0,1), acc[v].Slice(3,6,0,1)
+  // classical acceleration: dv + w x (vo + w x r)
+  vp.Add(vo, Cross(w, &r))
+  a := Cross(dw, &r)
+  a.Add(a, dvo)
+  a.Add(a, Cross(w, &vp))
+  var wb, dwb, ab mat.Dense
+  wb.Mul(rot, w)
+  dwb.Mul(rot, dw)
+  ab.Mul(rot, a)
+  return &wb, &dwb, &ab
+}
+
+// Constraint Jacobian and the acceleration term dJ*qd when bias is true
+func (base *Link) loopJacobian(mov []*Joint, bias bool) (*mat.Dense, *mat.Dense) {
+  m, n := base.loopSize(), len(mov)
+  if m == 0 {
+    return nil, nil
+  }
+  jac := mat.NewDense(m, n, nil)
+  var db *mat.Dense
+  var vel, acc map[*Link]*mat.Dense
+  if bias {
+    db = mat.NewDense(m, 1, nil)
+    vel, acc = base.biasMotion()
+  }
+  r := 0
+  for _, l := range base.Loops {
+    fa, fb := l.frames()
+    var d mat.Dense
+    d.Sub(l.B.pointJacobian(mov, fb.Pos), l.A.pointJacobian(mov, fa.Pos))
+    matInsert(r,0, jac, d.Slice(0,3,0,n))
+    var wa, wb, dw, rel mat.Dense
+    if bias {
+      w1, dw1, a1 := pointMotion(l.A, fa.Pos, vel, acc)
+      w2, dw2, a2 := pointMotion(l.B, fb.Pos, vel, acc)
+      wa.CloneFrom(w1)
+      wb.CloneFrom(w2)
+      dw.Sub(dw2, dw1)
+      rel.Sub(w2, w1)
+      var da mat.Dense
+      da.Sub(a2, a1)
+      matInsert(r,0, db, &da)
+    }
+    r += 3
+    for _, ax := range loopRot[l.Type] {
+      a, b := fa.Rot.Slice(0,3,ax.a,ax.a+1), fb.Rot.Slice(0,3,ax.b,ax.b+1)
+      // d(s*a.b)/dt = s*(b x a).(wb - wa)
+      g := Cross(b, a)
+      g.Scale(ax.s, g)
+      var row mat.Dense
+      row.Mul(g.T(), d.Slice(3,6,0,n))
+      matInsert(r,0, jac, &row)
+      if bias {
+        dg := Cross(Cross(&wb, b), a)
+        dg.Add(dg, Cross(b, Cross(&wa, a)))
+        dg.Scale(ax.s, dg)
+        db.Set(r, 0, mat.Dot(dg.ColView(0), rel.ColView(0)) + mat.Dot(g.ColView(0), dw.ColView(0)))
+      }
+      r++
+    }
+  }
+  return jac, db
+}
+
+// Minimal norm least squares solution, equations can be redundant
+func lstsq(a, b *mat.Dense) *mat.Dense {
+  _, c := a.Dims()
+  _, k := b.Dims()
+  res := mat.NewDense(c, k, nil)
+  var svd mat.SVD
+  if !svd.Factorize(a, mat.SVDThin) {
+    return res
+  }
+  s := svd.Values(nil)
+  rank := 0
+  for _, v := range s {
+    if v > 1E-9*s[0] {
+      rank++
+    }
+  }
+  if rank > 0 {
+    svd.SolveTo(res, b, rank)
+  }
+  return res
+}
+
+// Column of joint positions (k = 0), velocities (1) or accelerations (2)
+func stateVector(qs map[string][]float64, mov []*Joint, k int) *mat.Dense {
+  res := mat.NewDense(len(mov), 1, nil)
+  for i, jnt := range mov {
+    res.Set(i, 0, qs[jnt.Src.Name][k])
+  }
+  return res
+}
+
+// Constrained kinematics: correct positions, velocities and accelerations
+// of joints which are not active to close all loops (Newton projection),
+// when active is nil all joints are corrected with the minimal norm step,
+// the joint map and the tree state are updated, return false if positions do not converge
+func (base *Link) SolveLoops(qs map[string][]float64, active []*Joint) bool {
+  base.UpdateState(qs)
+  if len(base.Loops) == 0 {
+    return true
+  }
+  mov := base.MovableJoints()
+  act := jointIndex(active)
+  var free []*Joint
+  for _, jnt := range mov {
+    if _, ok := act[jnt]; !ok {
+      free = append(free, jnt)
+    }
+  }
+  correct := func(jf, e *mat.Dense, k int) {
+    d := lstsq(jf, e)
+    for i, jnt := range free {
+      qs[jnt.Src.Name][k] -= d.At(i,0)
+    }
+    base.UpdateState(qs)
+  }
+  ok := false
+  for it := 0; it < loopIter && len(free) > 0; it++ {
+    e := base.LoopError()
+    if ok = mat.Norm(e, math.Inf(1)) < loopTol; ok {
+      break
+    }
+    correct(base.LoopJacobian(free), e, 0)
+  }
+  if !ok {
+    return mat.Norm(base.LoopError(), math.Inf(1)) < loopTol
+  }
+  // velocities: J*qd = 0
+  jf := base.LoopJacobian(free)
+  var e mat.Dense
+  e.Mul(base.LoopJacobian(mov), stateVector(qs, mov, 1))
+  correct(jf, &e, 1)
+  // accelerations: J*qdd + dJ*qd = 0
+  jac, bias := base.loopJacobian(mov, true)
+  e.Mul(jac, stateVector(qs, mov, 2))
+  e.Add(&e, bias)
+  correct(jf, &e, 2)
+  return true
+}
+
+// Forward dynamics with closed loops and Lagrange multipliers:
+// M*qdd + C = tau + J^T*lambda, J*qdd + dJ*qd = 0,
+// torques and accelerations are in order of MovableJoints, accelerations are also
+// saved into the joint map, lambda contains forces and moments of loops acting on links B
+// (opposite on links A) in base frame, the state must satisfy constraints (see SolveLoops)
+func (base *Link) LoopForwardDynamics(qs map[string][]float64, tau *mat.Dense, ext []Wrench, g float64) (*mat.Dense, *mat.Dense, error) {
+  qdd0, err := base.ForwardDynamics(qs, tau, ext, g)
+  if err != nil || len(base.Loops) == 0 {
+    return qdd0, nil, err
+  }
+  mov := base.MovableJoints()
+  n := len(mov)
+  jac, bias := base.loopJacobian(mov, true)
+  var chol mat.Cholesky
+  if !chol.Factorize(mat.NewSymDense(n, base.MassMatrix(mov).RawMatrix().Data)) {
+    return nil, nil, errors.New("mass matrix is not positive definite")
+  }
+  // M^-1 * J^T
+  var mj, a, rhs, qdd mat.Dense
+  if err := chol.SolveTo(&mj, jac.T()); err != nil {
+    return nil, nil, err
+  }
+  a.Mul(jac, &mj)
+  rhs.Mul(jac, qdd0)
+  rhs.Add(&rhs, bias)
+  rhs.Scale(-1, &rhs)
+  lambda := lstsq(&a, &rhs)
+  qdd.Mul(&mj, lambda)
+  qdd.Add(&qdd, qdd0)
+  return saveAcc(mov, &qdd, qs), lambda, nil
+}
+
+// Torques of active joints for the current state which satisfies constraints,
+// passive joints are not actuated: M*qdd + C = S*tau_a + J^T*lambda,
+// torques are saved into joints, return active torques and multipliers
+func (base *Link) LoopInverseDynamics(active []*Joint, g float64) (*mat.Dense, *mat.Dense) {
+  base.UpdateDyn(g)
+  mov := base.MovableJoints()
+  ind := jointIndex(mov)
+  k, m := len(active), base.loopSize()
+  a := mat.NewDense(len(mov), k+m, nil)
+  for j, jnt := range active {
+    a.Set(ind[jnt], j, 1)
+  }
+  if m > 0 {
+    matInsert(0,k, a, base.LoopJacobian(mov).T())
+  }
+  x := lstsq(a, ReadTorques(mov))
+  tau := mat.DenseCopyOf(x.Slice(0,k,0,1))
+  var lambda *mat.Dense
+  if m > 0 {
+    lambda = mat.DenseCopyOf(x.Slice(k,k+m,0,1))
+  }
+  for _, jnt := range mov {
+    jnt.Tau = 0
+  }
+  for j, jnt := range active {
+    jnt.Tau = tau.At(j,0)
+  }
+  return tau, lambda
+}
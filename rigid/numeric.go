@@ -0,0 +1,128 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+  "math/rand"
+)
+
+// Numerical inverse kinematics with damped least squares,
+// each initial state (seed) gives one solution
+type Ik_Numeric struct {
+  ikSolution
+  Base     *Link
+  Ee       *Link
+  Mov      []*Joint      // movable joints
+  Seeds    [][]float64   // initial states
+  Tol      float64       // position and orientation error
+  Iter     int           // maximal number of iterations
+  Lambda   float64       // damping factor
+}
+
+// Prepare numerical solver for the chain with the given number of seeds,
+// the first seed is the zero state
+func (base *Link) NewIkNumeric(ee *Link, seeds int) *Ik_Numeric {
+  var par Ik_Numeric
+  par.Base, par.Ee = base, ee
+  par.Mov = ee.Predecessors()
+  par.Tol, par.Iter, par.Lambda = 1E-9, 100, 0.01
+  if seeds < 1 {
+    seeds = 1
+  }
+  rnd := rand.New(rand.NewSource(1))
+  par.Seeds = make([][]float64, seeds)
+  for i := range par.Seeds {
+    q := make([]float64, len(par.Mov))
+    if i > 0 {
+      for j, jnt := range par.Mov {
+        lo, up := jnt.Limit[0], jnt.Limit[1]
+        if lo >= up {
+          lo, up = -math.Pi, math.Pi
+        }
+        q[j] = lo + (up-lo)*rnd.Float64()
+      }
+    }
+    par.Seeds[i] = q
+  }
+  par.init(par.Mov, seeds)
+  return &par
+}
+
+// Inverse kinematics for the end effector (or its active tool),
+// the tree state is changed
+func (par *Ik_Numeric) IkFull(rot, pos *mat.Dense) {
+  qs := MakeJointMap(par.Mov)
+  q := make([]float64, len(par.Mov))
+  for col, seed := range par.Seeds {
+    copy(q, seed)
+    if par.Converge(q, rot, pos, qs) {
+      for i := range q {
+        par.Q.Set(i, col, q[i])
+      }
+    } else {
+      par.reject(col)
+    }
+  }
+}
+
+// Iterate from the state q to the desired pose,
+// return true if the error is less then tolerance
+func (par *Ik_Numeric) Converge(q []float64, rot, pos *mat.Dense, qs map[string][]float64) bool {
+  n := len(q)
+  var jjt mat.Dense
+  lam := par.Lambda * par.Lambda
+  for it := 0; it < par.Iter; it++ {
+    for i, jnt := range par.Mov {
+      qs[jnt.Src.Name][0] = q[i]
+    }
+    par.Base.UpdateState(qs)
+    e := poseError(rot, pos, par.Ee.Frame())
+    if mat.Norm(e, 2) < par.Tol {
+      return true
+    }
+    jac := par.Ee.Jacobian(par.Mov)
+    // dq = J^T (J J^T + lambda^2 I)^-1 e
+    jjt.Mul(jac, jac.T())
+    for i := 0; i < 6; i++ {
+      jjt.Set(i, i, jjt.At(i,i) + lam)
+    }
+    var y, dq mat.Dense
+    if err := y.Solve(&jjt, e); err != nil {
+      return false
+    }
+    dq.Mul(jac.T(), &y)
+    for i := 0; i < n; i++ {
+      q[i], _ = par.Mov[i].fitRange(q[i] + dq.At(i,0))
+      if lo, up := par.Mov[i].Limit[0], par.Mov[i].Limit[1]; lo < up {
+        q[i] = math.Max(lo, math.Min(up, q[i]))
+      }
+    }
+  }
+  return false
+}
+
+// Position and orientation error in base frame
+func poseError(rot, pos *mat.Dense, cur *Transform) *mat.Dense {
+  e := mat.NewDense(6,1,nil)
+  var d, dr mat.Dense
+  d.Sub(pos, cur.Pos)
+  matInsert(0,0, e, &d)
+  dr.Mul(rot, cur.Rot.T())
+  if theta, r, ok := toAA(&dr); ok {
+    matInsert(3,0, e, Txyz(theta*r[0], theta*r[1], theta*r[2]))
+  }
+  return e
+}
+
+// Manipulability measure, product of singular values of Jacobian
+func Manipulability(jac *mat.Dense) float64 {
+  var svd mat.SVD
+  if !svd.Factorize(jac, mat.SVDNone) {
+    return 0
+  }
+  res := 1.0
+  for _, v := range svd.Values(nil) {
+    res *= v
+  }
+  return res
+}
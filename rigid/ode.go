@@ -0,0 +1,425 @@
+package rigid
+
+import (
+  "math"
+  "sort"
+  "gonum.org/v1/gonum/mat"
+)
+
+// Single step of the classic Runge-Kutta method
+func Rk4Step(fn Ode, t float64, x *mat.Dense, h float64) *mat.Dense {
+  var tmp mat.Dense
+  h2 := 0.5 * h
+  k1 := fn(t, x)
+  tmp.Scale(h2, k1); tmp.Add(&tmp, x)
+  k2 := fn(t+h2, &tmp)
+  tmp.Scale(h2, k2); tmp.Add(&tmp, x)
+  k3 := fn(t+h2, &tmp)
+  tmp.Scale(h, k3); tmp.Add(&tmp, x)
+  k4 := fn(t+h, &tmp)
+  // x + h*(k1 + 2*k2 + 2*k3 + k4)/6
+  res := mat.DenseCopyOf(x)
+  tmp.Scale(h/6, k1); res.Add(res, &tmp)
+  tmp.Scale(h/3, k2); res.Add(res, &tmp)
+  tmp.Scale(h/3, k3); res.Add(res, &tmp)
+  tmp.Scale(h/6, k4); res.Add(res, &tmp)
+  return res
+}
+
+// Runge-Kutta-Fehlberg coefficients
+var (
+  rkfC = []float64{0, 1.0/4, 3.0/8, 12.0/13, 1, 1.0/2}
+  rkfA = [][]float64{
+    {},
+    {1.0/4},
+    {3.0/32, 9.0/32},
+    {1932.0/2197, -7200.0/2197, 7296.0/2197},
+    {439.0/216, -8, 3680.0/513, -845.0/4104},
+    {-8.0/27, 2, -3544.0/2565, 1859.0/4104, -11.0/40}}
+  rkfB5 = []float64{16.0/135, 0, 6656.0/12825, 28561.0/56430, -9.0/50, 2.0/55}
+  rkfB4 = []float64{25.0/216, 0, 1408.0/2565, 2197.0/4104, -1.0/5, 0}
+)
+
+// Single step of the Runge-Kutta-Fehlberg 4(5) method,
+// return the 5th order state and the maximal difference with the 4th order one
+func Rkf45Step(fn Ode, t float64, x *mat.Dense, h float64) (*mat.Dense, float64) {
+  k := make([]*mat.Dense, len(rkfC))
+  var tmp, xi mat.Dense
+  for i := range k {
+    xi.CloneFrom(x)
+    for j, a := range rkfA[i] {
+      tmp.Scale(h*a, k[j]); xi.Add(&xi, &tmp)
+    }
+    k[i] = fn(t + rkfC[i]*h, &xi)
+  }
+  res := mat.DenseCopyOf(x)
+  var e mat.Dense
+  for i := range k {
+    tmp.Scale(h*rkfB5[i], k[i]); res.Add(res, &tmp)
+    tmp.Scale(h*(rkfB5[i]-rkfB4[i]), k[i])
+    if i == 0 {
+      e.CloneFrom(&tmp)
+    } else {
+      e.Add(&e, &tmp)
+    }
+  }
+  return res, mat.Norm(&e, math.Inf(1))
+}
+
+// Adaptive ODE methods
+type OdeMethod int
+const (
+  Ode_Dopri5 OdeMethod = iota    // explicit Dormand-Prince 5(4)
+  Ode_Rosenbrock                 // linearly implicit 2(3) method for stiff problems
+)
+
+// Zero crossing function, integration can be stopped at event
+type OdeEvent struct {
+  Fn         func(float64, *mat.Dense) float64
+  Terminal   bool
+  Direction  int      // 1 for rising, -1 for falling, 0 for both
+}
+
+// Found event
+type OdeHit struct {
+  Event  int          // index in the list of events
+  T      float64
+  X      *mat.Dense
+}
+
+// Parameters of the adaptive solver
+type OdeOptions struct {
+  Method   OdeMethod
+  RelTol   float64
+  AbsTol   float64
+  H0       float64    // initial step, estimated when zero
+  MinStep  float64
+  MaxStep  float64    // no limit when zero
+  Events   []OdeEvent
+}
+
+// Default solver parameters
+func NewOdeOptions() *OdeOptions {
+  return &OdeOptions{RelTol: 1E-6, AbsTol: 1E-9, MinStep: 1E-12}
+}
+
+// Integration result with dense output
+type OdeSolution struct {
+  T      []float64       // accepted steps
+  X      []*mat.Dense
+  Hits   []OdeHit
+  Ok     bool            // false if the step becomes too small
+  interp []func(float64) *mat.Dense   // interpolation on step, argument from 0 to 1
+}
+
+// State at time t from the dense output
+func (sol *OdeSolution) At(t float64) *mat.Dense {
+  n := len(sol.T)
+  if n == 0 {
+    return nil
+  }
+  if n == 1 || t <= sol.T[0] {
+    return mat.DenseCopyOf(sol.X[0])
+  }
+  if t >= sol.T[n-1] {
+    return mat.DenseCopyOf(sol.X[n-1])
+  }
+  i := sort.SearchFloat64s(sol.T, t)   // T[i-1] < t <= T[i]
+  t0 := sol.T[i-1]
+  return sol.interp[i-1]((t - t0) / (sol.T[i] - t0))
+}
+
+// Final state
+func (sol *OdeSolution) Last() *mat.Dense {
+  return sol.X[len(sol.X)-1]
+}
+
+// Step of adaptive method: new state, error estimate and interpolation
+type odeStep func(t float64, x, f0 *mat.Dense, h float64) (*mat.Dense, *mat.Dense, func(float64) *mat.Dense)
+
+// Integrate from t0 to tn with error control
+func OdeAdaptive(fn Ode, t0 float64, x0 *mat.Dense, tn float64, opt *OdeOptions) *OdeSolution {
+  if opt == nil {
+    opt = NewOdeOptions()
+  }
+  var step odeStep
+  order := 5.0
+  switch opt.Method {
+  case Ode_Rosenbrock:
+    step, order = rosenbrockStep(fn), 3
+  default:
+    step = dopri5Step(fn)
+  }
+  sol := &OdeSolution{Ok: true}
+  x := mat.DenseCopyOf(x0)
+  sol.T, sol.X = append(sol.T, t0), append(sol.X, x)
+  f0 := fn(t0, x)
+  hmax := opt.MaxStep
+  if hmax <= 0 {
+    hmax = math.Abs(tn - t0)
+  }
+  h := opt.H0
+  if h <= 0 {
+    h = initialStep(x, f0, opt, hmax)
+  }
+  g0 := eventValues(opt.Events, t0, x)
+
+  for t := t0; tn - t > opt.MinStep; {
+    h = math.Min(h, tn - t)
+    xn, e, fi := step(t, x, f0, h)
+    err := errorNorm(e, x, xn, opt)
+    if err > 1 && h > opt.MinStep {
+      h = math.Max(opt.MinStep, h*math.Max(0.2, 0.9*math.Pow(err, -1/order)))
+      continue
+    }
+    if err > 1 {
+      sol.Ok = false
+    }
+    tp := t
+    t += h
+    // event detection
+    if len(opt.Events) > 0 {
+      g1 := eventValues(opt.Events, t, xn)
+      if k, te, xe := firstEvent(opt.Events, g0, g1, tp, h, fi); k >= 0 {
+        sol.Hits = append(sol.Hits, OdeHit{Event: k, T: te, X: xe})
+        if opt.Events[k].Terminal {
+          sol.T, sol.X = append(sol.T, te), append(sol.X, xe)
+          sol.interp = append(sol.interp, scaledInterp(fi, (te-tp)/h))
+          return sol
+        }
+      }
+      g0 = g1
+    }
+    sol.T, sol.X = append(sol.T, t), append(sol.X, xn)
+    sol.interp = append(sol.interp, fi)
+    x = xn
+    f0 = fn(t, x)
+    // next step
+    k := 5.0
+    if err > 0 {
+      k = math.Min(k, 0.9*math.Pow(err, -1/order))
+    }
+    h = math.Min(hmax, math.Max(opt.MinStep, h*math.Max(0.2, k)))
+  }
+  return sol
+}
+
+// Interpolation on the part [0,s] of the step
+func scaledInterp(fi func(float64) *mat.Dense, s float64) func(float64) *mat.Dense {
+  return func(th float64) *mat.Dense { return fi(th * s) }
+}
+
+// Initial step estimation
+func initialStep(x, f0 *mat.Dense, opt *OdeOptions, hmax float64) float64 {
+  d0, d1 := 0.0, 0.0
+  r, _ := x.Dims()
+  for i := 0; i < r; i++ {
+    sc := opt.AbsTol + opt.RelTol*math.Abs(x.At(i,0))
+    d0 = math.Max(d0, math.Abs(x.At(i,0))/sc)
+    d1 = math.Max(d1, math.Abs(f0.At(i,0))/sc)
+  }
+  h := 1E-6
+  if d0 > 1E-5 && d1 > 1E-5 {
+    h = 0.01 * d0 / d1
+  }
+  return math.Max(opt.MinStep, math.Min(h, hmax))
+}
+
+// Root mean square of the scaled error
+func errorNorm(e, x, xn *mat.Dense, opt *OdeOptions) float64 {
+  r, _ := x.Dims()
+  sum := 0.0
+  for i := 0; i < r; i++ {
+    sc := opt.AbsTol + opt.RelTol*math.Max(math.Abs(x.At(i,0)), math.Abs(xn.At(i,0)))
+    v := e.At(i,0) / sc
+    sum += v*v
+  }
+  return math.Sqrt(sum / float64(r))
+}
+
+// Evaluate all event functions
+func eventValues(ev []OdeEvent, t float64, x *mat.Dense) []float64 {
+  res := make([]float64, len(ev))
+  for i := range ev {
+    res[i] = ev[i].Fn(t, x)
+  }
+  return res
+}
+
+// Find the earliest zero crossing on the step,
+// return event index (-1 if not found), its time and state
+func firstEvent(ev []OdeEvent, g0, g1 []float64, t, h float64, fi func(float64) *mat.Dense) (int, float64, *mat.Dense) {
+  k, best := -1, 2.0
+  for i := range ev {
+    a, b := g0[i], g1[i]
+    if a == 0 || a*b > 0 {
+      continue
+    }
+    if (ev[i].Direction > 0 && b < a) || (ev[i].Direction < 0 && b > a) {
+      continue
+    }
+    // Illinois method on the interpolation
+    gf := func(s float64) float64 { return ev[i].Fn(t + s*h, fi(s)) }
+    s0, s1, side := 0.0, 1.0, 0
+    for it := 0; it < 60 && b != 0 && s1 - s0 > 1E-12; it++ {
+      s := (s0*b - s1*a) / (b - a)
+      v := gf(s)
+      if v*b > 0 {
+        s1, b = s, v
+        if side == -1 { a *= 0.5 }
+        side = -1
+      } else {
+        s0, a = s, v
+        if side == 1 { b *= 0.5 }
+        side = 1
+      }
+      if v == 0 {
+        s1 = s
+        break
+      }
+    }
+    if s1 < best {
+      k, best = i, s1
+    }
+  }
+  if k < 0 {
+    return -1, 0, nil
+  }
+  return k, t + best*h, fi(best)
+}
+
+// Dormand-Prince coefficients
+var (
+  dpC = []float64{0, 1.0/5, 3.0/10, 4.0/5, 8.0/9, 1, 1}
+  dpA = [][]float64{
+    {},
+    {1.0/5},
+    {3.0/40, 9.0/40},
+    {44.0/45, -56.0/15, 32.0/9},
+    {19372.0/6561, -25360.0/2187, 64448.0/6561, -212.0/729},
+    {9017.0/3168, -355.0/33, 46732.0/5247, 49.0/176, -5103.0/18656},
+    {35.0/384, 0, 500.0/1113, 125.0/192, -2187.0/6784, 11.0/84}}
+  dpE = []float64{71.0/57600, 0, -71.0/16695, 71.0/1920, -17253.0/339200, 22.0/525, -1.0/40}
+  dpD = []float64{-12715105075.0/11282082432, 0, 87487479700.0/32700410799,
+    -10690763975.0/1880347072, 701980252875.0/199316789632, -1453857185.0/822651844, 69997945.0/29380423}
+)
+
+// Linear combination x + h*sum(c[i]*k[i])
+func combine(x *mat.Dense, h float64, c []float64, k []*mat.Dense) *mat.Dense {
+  res := mat.DenseCopyOf(x)
+  var tmp mat.Dense
+  for i, ci := range c {
+    if ci != 0 {
+      tmp.Scale(h*ci, k[i])
+      res.Add(res, &tmp)
+    }
+  }
+  return res
+}
+
+// Dormand-Prince step with 4th order dense output
+func dopri5Step(fn Ode) odeStep {
+  return func(t float64, x, f0 *mat.Dense, h float64) (*mat.Dense, *mat.Dense, func(float64) *mat.Dense) {
+    k := make([]*mat.Dense, 7)
+    k[0] = f0
+    for i := 1; i < 7; i++ {
+      k[i] = fn(t + dpC[i]*h, combine(x, h, dpA[i], k))
+    }
+    xn := combine(x, h, dpA[6], k)
+    r, _ := x.Dims()
+    e := combine(mat.NewDense(r,1,nil), h, dpE, k)
+    // continuous extension
+    var dy, bspl, r4 mat.Dense
+    dy.Sub(xn, x)
+    bspl.Scale(h, k[0]); bspl.Sub(&bspl, &dy)
+    r4.Scale(h, k[6]); r4.Sub(&dy, &r4); r4.Sub(&r4, &bspl)
+    r5 := combine(mat.NewDense(r,1,nil), h, dpD, k)
+    fi := func(th float64) *mat.Dense {
+      // x + th*(dy + (1-th)*(bspl + th*(r4 + (1-th)*r5)))
+      var v mat.Dense
+      v.Scale(1-th, r5); v.Add(&v, &r4)
+      v.Scale(th, &v); v.Add(&v, &bspl)
+      v.Scale(1-th, &v); v.Add(&v, &dy)
+      v.Scale(th, &v)
+      v.Add(&v, x)
+      return &v
+    }
+    return xn, e, fi
+  }
+}
+
+// Numerical Jacobian df/dx and derivative df/dt
+func odeJacobian(fn Ode, t float64, x, f0 *mat.Dense) (*mat.Dense, *mat.Dense) {
+  r, _ := x.Dims()
+  jac := mat.NewDense(r, r, nil)
+  xi := mat.DenseCopyOf(x)
+  var df mat.Dense
+  for j := 0; j < r; j++ {
+    v := x.At(j,0)
+    d := 1E-7 * math.Max(1, math.Abs(v))
+    xi.Set(j, 0, v + d)
+    df.Sub(fn(t, xi), f0)
+    df.Scale(1/d, &df)
+    jac.SetCol(j, mat.Col(nil, 0, &df))
+    xi.Set(j, 0, v)
+  }
+  dt := 1E-7 * math.Max(1, math.Abs(t))
+  var ft mat.Dense
+  ft.Sub(fn(t + dt, x), f0)
+  ft.Scale(1/dt, &ft)
+  return jac, &ft
+}
+
+// Rosenbrock step of order 2 with 3rd order error estimate (Shampine, Reichelt),
+// Jacobian is found numerically
+func rosenbrockStep(fn Ode) odeStep {
+  d := 1 / (2 + math.Sqrt2)
+  e32 := 6 + math.Sqrt2
+  return func(t float64, x, f0 *mat.Dense, h float64) (*mat.Dense, *mat.Dense, func(float64) *mat.Dense) {
+    r, _ := x.Dims()
+    jac, ft := odeJacobian(fn, t, x, f0)
+    // W = I - h*d*J
+    w := mat.NewDense(r, r, nil)
+    w.Scale(-h*d, jac)
+    for i := 0; i < r; i++ {
+      w.Set(i, i, w.At(i,i) + 1)
+    }
+    var lu mat.LU
+    lu.Factorize(w)
+    var hdt, rhs, tmp mat.Dense
+    hdt.Scale(h*d, ft)
+    // k1 = W \ (F0 + h*d*T)
+    k1 := new(mat.Dense)
+    rhs.Add(f0, &hdt)
+    lu.SolveTo(k1, false, &rhs)
+    // k2 = W \ (F1 - k1) + k1
+    tmp.Scale(0.5*h, k1); tmp.Add(&tmp, x)
+    f1 := fn(t + 0.5*h, &tmp)
+    k2 := new(mat.Dense)
+    rhs.Sub(f1, k1)
+    lu.SolveTo(k2, false, &rhs)
+    k2.Add(k2, k1)
+    xn := new(mat.Dense)
+    xn.Scale(h, k2); xn.Add(xn, x)
+    // k3 = W \ (F2 - e32*(k2 - F1) - 2*(k1 - F0) + h*d*T)
+    f2 := fn(t + h, xn)
+    k3 := new(mat.Dense)
+    rhs.Sub(k2, f1); rhs.Scale(-e32, &rhs); rhs.Add(&rhs, f2)
+    tmp.Sub(k1, f0); tmp.Scale(-2, &tmp); rhs.Add(&rhs, &tmp)
+    rhs.Add(&rhs, &hdt)
+    lu.SolveTo(k3, false, &rhs)
+    // error h/6*(k1 - 2*k2 + k3)
+    e := new(mat.Dense)
+    e.Scale(-2, k2); e.Add(e, k1); e.Add(e, k3)
+    e.Scale(h/6, e)
+    fi := func(th float64) *mat.Dense {
+      var v, u mat.Dense
+      v.Scale(h*th*(1-th)/(1-2*d), k1)
+      u.Scale(h*th*(th-2*d)/(1-2*d), k2)
+      v.Add(&v, &u)
+      v.Add(&v, x)
+      return &v
+    }
+    return xn, e, fi
+  }
+}
@@ -0,0 +1,163 @@
+package rigid
+
+import (
+  "math"
+)
+
+// Parameters of point to point motion
+type PtpOptions struct {
+  Vel    float64   // velocity limit when it is not defined in URDF
+  Acc    float64   // acceleration limit
+  Jerk   float64   // jerk limit, trapezoidal profiles are used when 0
+  Phase  bool      // phase synchronization, the path is a line in joint space
+}
+
+// Default motion parameters
+func NewPtpOptions() *PtpOptions {
+  return &PtpOptions{Vel: 1, Acc: 2}
+}
+
+// Profile with normalized position (Profile or SCurve)
+type normProfile interface {
+  At(k, period float64, res []float64) float64
+}
+
+// Synchronized point to point motion, all joints start and finish together,
+// joints are in order of the list used for planning
+type PtpMotion struct {
+  Q0, Q1  []float64
+  T       float64           // duration
+  prof    []normProfile     // nil for joints without motion
+}
+
+// Plan motion from q0 to q1 for the joints with their velocity limits,
+// each profile is slowed down (velocity is reduced) to the duration of the slowest joint,
+// with phase synchronization all joints use the same normalized profile
+func PlanPtp(mov []*Joint, q0, q1 []float64, opt *PtpOptions) *PtpMotion {
+  n := len(mov)
+  res := &PtpMotion{Q0: append([]float64{}, q0...), Q1: append([]float64{}, q1...)}
+  res.prof = make([]normProfile, n)
+  vel, dist := velLimits(mov, opt), make([]float64, n)
+  for i := range mov {
+    dist[i] = math.Abs(q1[i] - q0[i])
+  }
+  if opt.Phase {
+    // limits for the normalized motion
+    v, a, j := math.Inf(1), math.Inf(1), math.Inf(1)
+    for i := range mov {
+      if dist[i] > 0 {
+        v = math.Min(v, vel[i] / dist[i])
+        a = math.Min(a, opt.Acc / dist[i])
+        j = math.Min(j, opt.Jerk / dist[i])
+      }
+    }
+    if math.IsInf(v, 1) {
+      return res
+    }
+    var p normProfile
+    if opt.Jerk > 0 {
+      sc := NewSCurve(1, 0, 0, v, a, j)
+      p, res.T = sc, sc.T
+    } else {
+      tr := Trapez(1, v, a)
+      p, res.T = tr, tr.T
+    }
+    for i := range mov {
+      if dist[i] > 0 {
+        res.prof[i] = p
+      }
+    }
+    return res
+  }
+  // duration of the slowest joint
+  for i := range mov {
+    if dist[i] > 0 {
+      res.T = math.Max(res.T, ptpTime(dist[i], vel[i], opt))
+    }
+  }
+  // reduce velocities
+  for i := range mov {
+    if dist[i] == 0 {
+      continue
+    }
+    if opt.Jerk > 0 {
+      // the duration decreases with velocity
+      lo, up := 0.0, vel[i]
+      sc := NewSCurve(dist[i], 0, 0, up, opt.Acc, opt.Jerk)
+      for it := 0; it < 60 && sc.T < res.T; it++ {
+        v := 0.5*(lo + up)
+        if p := NewSCurve(dist[i], 0, 0, v, opt.Acc, opt.Jerk); p.T > res.T {
+          lo = v
+        } else {
+          up, sc = v, p
+        }
+      }
+      res.prof[i] = sc
+    } else {
+      // v^2 - a*T*v + a*d = 0
+      a := opt.Acc
+      v := 0.5*(a*res.T - math.Sqrt(math.Max(0, a*a*res.T*res.T - 4*a*dist[i])))
+      res.prof[i] = Trapez(dist[i], math.Min(v, vel[i]), a)
+    }
+  }
+  return res
+}
+
+// Joint velocity limits, default value is used when it is not defined in URDF
+func velLimits(mov []*Joint, opt *PtpOptions) []float64 {
+  res := make([]float64, len(mov))
+  for i, jnt := range mov {
+    res[i] = jnt.VelLimit
+    if res[i] <= 0 {
+      res[i] = opt.Vel
+    }
+  }
+  return res
+}
+
+// Minimal time of one joint motion
+func ptpTime(d, vmax float64, opt *PtpOptions) float64 {
+  if opt.Jerk > 0 {
+    return NewSCurve(d, 0, 0, vmax, opt.Acc, opt.Jerk).T
+  }
+  return Trapez(d, vmax, opt.Acc).T
+}
+
+// Motion duration
+func (m *PtpMotion) Duration() float64 {
+  return m.T
+}
+
+// Joint positions, velocities and accelerations at time t,
+// the results can be nil
+func (m *PtpMotion) At(t float64, q, qd, qdd []float64) {
+  k := 1.0
+  if m.T > 0 {
+    k = math.Max(0, math.Min(1, t / m.T))
+  }
+  st := make([]float64, 3)
+  for i, p := range m.prof {
+    d := m.Q1[i] - m.Q0[i]
+    st[0], st[1], st[2] = k, 0, 0
+    if p != nil {
+      p.At(k, m.T, st)
+    }
+    if q != nil {
+      q[i] = m.Q0[i] + d*st[0]
+    }
+    if qd != nil {
+      qd[i] = d*st[1]
+    }
+    if qdd != nil {
+      qdd[i] = d*st[2]
+    }
+  }
+}
+
+// Sample motion in n+1 points,
+// return joint path with relative time in S and absolute time stamps
+func (m *PtpMotion) Path(n int) (Path, []float64) {
+  return samplePath(func (t float64, q []float64) {
+    m.At(t, q, nil, nil)
+  }, len(m.Q0), 0, m.T, n)
+}
@@ -0,0 +1,279 @@
+package rigid
+
+import (
+  "encoding/json"
+  "gonum.org/v1/gonum/mat"
+  "io/ioutil"
+  "math"
+  "math/bits"
+  "math/rand"
+  "sync"
+)
+
+// Reachability map on the voxel grid in base frame,
+// orientations are represented with directions of the tool axis
+type ReachMap struct {
+  Min    [3]float64     // lower corner
+  Step   float64        // voxel size
+  N      [3]int         // number of voxels
+  Axis   int            // tool axis (column of rotation matrix)
+  Dirs   [][3]float64   // direction bins, at most 64
+  Hits   []uint64       // reached directions for each voxel
+  Manip  []float64      // maximal manipulability for each voxel
+}
+
+// Constructor for region [lo,hi] with the given voxel size
+// and number of direction bins
+func NewReachMap(lo, hi [3]float64, step float64, dirs int) *ReachMap {
+  m := new(ReachMap)
+  m.Min, m.Step, m.Axis = lo, step, 2
+  total := 1
+  for i := 0; i < 3; i++ {
+    m.N[i] = int(math.Ceil((hi[i]-lo[i]) / step))
+    if m.N[i] < 1 {
+      m.N[i] = 1
+    }
+    total *= m.N[i]
+  }
+  if dirs > 64 {
+    dirs = 64
+  } else if dirs < 1 {
+    dirs = 1
+  }
+  m.Dirs = sphereDirections(dirs)
+  m.Hits = make([]uint64, total)
+  m.Manip = make([]float64, total)
+  return m
+}
+
+// Approximately uniform directions (Fibonacci sphere)
+func sphereDirections(n int) [][3]float64 {
+  res := make([][3]float64, n)
+  golden := math.Pi * (3 - math.Sqrt(5))
+  for i := range res {
+    z := 1 - (2*float64(i)+1) / float64(n)
+    r := math.Sqrt(1 - z*z)
+    s, c := math.Sincos(golden * float64(i))
+    res[i] = [3]float64{r*c, r*s, z}
+  }
+  return res
+}
+
+// Voxel index for position, -1 if it is outside
+func (m *ReachMap) Index(pos *mat.Dense) int {
+  ind := 0
+  for i := 2; i >= 0; i-- {
+    k := int(math.Floor((pos.At(i,0) - m.Min[i]) / m.Step))
+    if k < 0 || k >= m.N[i] {
+      return -1
+    }
+    ind = ind*m.N[i] + k
+  }
+  return ind
+}
+
+// Center of voxel
+func (m *ReachMap) Center(ind int) *mat.Dense {
+  res := zero31()
+  for i := 0; i < 3; i++ {
+    k := ind % m.N[i]
+    ind /= m.N[i]
+    res.Set(i, 0, m.Min[i] + (float64(k)+0.5)*m.Step)
+  }
+  return res
+}
+
+// Closest direction bin for the tool axis
+func (m *ReachMap) bin(rot *mat.Dense) int {
+  res, best := 0, math.Inf(-1)
+  for i, d := range m.Dirs {
+    v := d[0]*rot.At(0,m.Axis) + d[1]*rot.At(1,m.Axis) + d[2]*rot.At(2,m.Axis)
+    if v > best {
+      res, best = i, v
+    }
+  }
+  return res
+}
+
+// Part of reached directions in voxel
+func (m *ReachMap) Score(ind int) float64 {
+  return float64(bits.OnesCount64(m.Hits[ind])) / float64(len(m.Dirs))
+}
+
+// Rotation with the tool axis along direction bin
+func (m *ReachMap) Orientation(k int) *mat.Dense {
+  d := m.Dirs[k]
+  z := Txyz(d[0], d[1], d[2])
+  // any orthogonal vector
+  x := Txyz(1, 0, 0)
+  if math.Abs(d[0]) > 0.9 {
+    x = Txyz(0, 1, 0)
+  }
+  y := Cross(z, x)
+  y.Scale(1/mat.Norm(y,2), y)
+  x = Cross(y, z)
+  res := eye33()
+  cols := []*mat.Dense{x, y, z}
+  for i := 0; i < 3; i++ {
+    matInsert(0, (m.Axis+1+i) % 3, res, cols[i])
+  }
+  return res
+}
+
+// Save sample into map
+func (m *ReachMap) add(ind, dir int, w float64) {
+  m.Hits[ind] |= 1 << uint(dir)
+  if w > m.Manip[ind] {
+    m.Manip[ind] = w
+  }
+}
+
+// Add results from another map with the same grid
+func (m *ReachMap) merge(src *ReachMap) {
+  for i := range m.Hits {
+    m.Hits[i] |= src.Hits[i]
+    if src.Manip[i] > m.Manip[i] {
+      m.Manip[i] = src.Manip[i]
+    }
+  }
+}
+
+// Empty map with the same grid
+func (m *ReachMap) emptyCopy() *ReachMap {
+  res := *m
+  res.Hits = make([]uint64, len(m.Hits))
+  res.Manip = make([]float64, len(m.Manip))
+  return &res
+}
+
+// Fill map with random joint states (forward kinematics),
+// each worker uses own copy of the tree
+func (m *ReachMap) SampleJoints(base, ee *Link, samples, workers int, seed int64) {
+  if workers < 1 {
+    workers = 1
+  }
+  var wg sync.WaitGroup
+  parts := make([]*ReachMap, workers)
+  for w := 0; w < workers; w++ {
+    parts[w] = m.emptyCopy()
+    // the first workers take the remainder
+    n := samples / workers
+    if w < samples % workers {
+      n++
+    }
+    wg.Add(1)
+    go func(part *ReachMap, b *Link, n int, rnd *rand.Rand) {
+      defer wg.Done()
+      e := b.Find(ee.Src.Name)
+      mov := e.Predecessors()
+      qs := MakeJointMap(mov)
+      for k := 0; k < n; k++ {
+        for _, jnt := range mov {
+          lo, up := jnt.Limit[0], jnt.Limit[1]
+          if lo >= up {
+            lo, up = -math.Pi, math.Pi
+          }
+          qs[jnt.Src.Name][0] = lo + (up-lo)*rnd.Float64()
+        }
+        b.UpdateState(qs)
+        fr := e.Frame()
+        if ind := part.Index(fr.Pos); ind >= 0 {
+          part.add(ind, part.bin(fr.Rot), Manipulability(e.Jacobian(mov)))
+        }
+      }
+    }(parts[w], base.GetCopy(), n, rand.New(rand.NewSource(seed+int64(w))))
+  }
+  wg.Wait()
+  for _, part := range parts {
+    m.merge(part)
+  }
+}
+
+// Solver constructor for tree copy
+type IkFactory func(base, ee *Link) IkSolver
+
+// Fill map with IK solutions for each voxel and direction,
+// each worker uses own copy of the tree and solver
+func (m *ReachMap) SampleIk(base, ee *Link, factory IkFactory, workers int) {
+  if workers < 1 {
+    workers = 1
+  }
+  jobs := make(chan int)
+  var wg sync.WaitGroup
+  for w := 0; w < workers; w++ {
+    wg.Add(1)
+    go func(b *Link) {
+      defer wg.Done()
+      e := b.Find(ee.Src.Name)
+      mov := e.Predecessors()
+      solver := factory(b, e)
+      rots := make([]*mat.Dense, len(m.Dirs))
+      for k := range rots {
+        rots[k] = m.Orientation(k)
+      }
+      qs := MakeJointMap(mov)
+      for ind := range jobs {
+        pos := m.Center(ind)
+        for k, rot := range rots {
+          solver.IkFull(rot, pos)
+          if reachable(solver, mov, qs) {
+            b.UpdateState(qs)
+            // each voxel is processed by one worker
+            m.add(ind, k, Manipulability(e.Jacobian(mov)))
+          }
+        }
+      }
+    }(base.GetCopy())
+  }
+  for ind := range m.Hits {
+    jobs <- ind
+  }
+  close(jobs)
+  wg.Wait()
+}
+
+// Find any solution within joint limits and save it into the map
+func reachable(solver IkSolver, mov []*Joint, qs map[string][]float64) bool {
+  _, cols := solver.Solutions().Dims()
+  for c := 0; c < cols; c++ {
+    if math.IsNaN(solver.Solutions().At(0,c)) {
+      continue
+    }
+    solver.SetTo(qs, c)
+    ok := true
+    for _, jnt := range mov {
+      q, in := jnt.fitRange(qs[jnt.Src.Name][0])
+      if !in || math.IsNaN(q) {
+        ok = false
+        break
+      }
+      qs[jnt.Src.Name][0] = q
+    }
+    if ok {
+      return true
+    }
+  }
+  return false
+}
+
+// Save map in JSON format
+func (m *ReachMap) Save(fname string) error {
+  data, err := json.Marshal(m)
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(fname, data, 0644)
+}
+
+// Load map from JSON file
+func LoadReachMap(fname string) (*ReachMap, error) {
+  data, err := ioutil.ReadFile(fname)
+  if err != nil {
+    return nil, err
+  }
+  m := new(ReachMap)
+  if err = json.Unmarshal(data, m); err != nil {
+    return nil, err
+  }
+  return m, nil
+}
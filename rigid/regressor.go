@@ -0,0 +1,310 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+  "math/rand"
+)
+
+// Number of inertial parameters of body:
+// m, m*cx, m*cy, m*cz, Ixx, Ixy, Ixz, Iyy, Iyz, Izz,
+// inertia is w.r.t. the link origin
+const param_Body = 10
+
+// Inertial parameters as vector
+func (d *Inertial) Params() []float64 {
+  res := make([]float64, param_Body)
+  res[0] = d.M
+  for i := 0; i < 3; i++ {
+    res[1+i] = d.M * d.Rc.At(i,0)
+  }
+  var io mat.Dense
+  io.Add(d.I, shiftInertia(d.M, d.Rc))
+  res[4], res[5], res[6] = io.At(0,0), io.At(0,1), io.At(0,2)
+  res[7], res[8], res[9] = io.At(1,1), io.At(1,2), io.At(2,2)
+  return res
+}
+
+// Mass, mass center and central inertia from the parameter vector
+func InertialFromParams(p []float64) Inertial {
+  var res Inertial
+  res.M = p[0]
+  res.Rc = zero31()
+  if res.M != 0 {
+    res.Rc = Txyz(p[1]/res.M, p[2]/res.M, p[3]/res.M)
+  }
+  res.I = mat.NewDense(3,3, []float64{
+    p[4], p[5], p[6],
+    p[5], p[7], p[8],
+    p[6], p[8], p[9]})
+  res.I.Sub(res.I, shiftInertia(res.M, res.Rc))
+  return res
+}
+
+// Spatial inertia w.r.t. link origin for the parameter vector
+func paramSpatial(p []float64) *mat.Dense {
+  res := mat.NewDense(6,6,nil)
+  matInsert(0,0, res, mat.NewDense(3,3, []float64{
+    p[4], p[5], p[6],
+    p[5], p[7], p[8],
+    p[6], p[8], p[9]}))
+  h := skew(Txyz(p[1], p[2], p[3]))
+  matInsert(0,3, res, h)
+  matInsert(3,0, res, h.T())
+  matInsert(3,3, res, mat.NewDiagDense(3, []float64{p[0], p[0], p[0]}))
+  return res
+}
+
+// Links with dynamical parameters (all except root), parents before children
+func (base *Link) DynLinks() []*Link {
+  var res []*Link
+  base.forEach(func (v *Link) {
+    if v.Parent != nil {
+      res = append(res, v)
+    }
+  })
+  return res
+}
+
+// Current parameters of links in order of DynLinks (active tools are included)
+func (base *Link) Parameters() *mat.Dense {
+  lnk := base.DynLinks()
+  res := mat.NewDense(param_Body*len(lnk), 1, nil)
+  for i, v := range lnk {
+    for k, p := range v.inertial().Params() {
+      res.Set(param_Body*i + k, 0, p)
+    }
+  }
+  return res
+}
+
+// Set link parameters in order of DynLinks,
+// payload of active tools is excluded
+func (base *Link) SetParameters(pi *mat.Dense) {
+  p := make([]float64, param_Body)
+  for i, v := range base.DynLinks() {
+    for k := range p {
+      p[k] = pi.At(param_Body*i + k, 0)
+    }
+    if v.Tool != nil && v.Tool.Dyn.M != 0 {
+      for k, tp := range v.Tool.inertial().Params() {
+        p[k] -= tp
+      }
+    }
+    v.Dyn = InertialFromParams(p)
+  }
+}
+
+// Spatial velocities and accelerations of links for the current joint state,
+// acc is the base acceleration (gravity)
+func (v *Link) motion(vp, ap *mat.Dense, vel, acc map[*Link]*mat.Dense) {
+  for _, jnt := range v.Joints {
+    s := jnt.subspace()
+    x := jnt.xform()
+    var vj, tmp mat.Dense
+    vj.Scale(jnt.Vel, s)
+    vi := mat.NewDense(6,1,nil)
+    vi.Mul(x, vp)
+    vi.Add(vi, &vj)
+    ai := mat.NewDense(6,1,nil)
+    ai.Mul(x, ap)
+    tmp.Scale(jnt.Acc, s)
+    ai.Add(ai, &tmp)
+    tmp.Mul(crossMotion(vi), &vj)
+    ai.Add(ai, &tmp)
+    vel[jnt.Child], acc[jnt.Child] = vi, ai
+    jnt.Child.motion(vi, ai, vel, acc)
+  }
+}
+
+// Regressor of link forces: f = A*pi
+func forceRegressor(v, a *mat.Dense) *mat.Dense {
+  res := mat.NewDense(6, param_Body, nil)
+  p := make([]float64, param_Body)
+  cf := crossForce(v)
+  var f, tmp mat.Dense
+  for k := range p {
+    p[k] = 1
+    ik := paramSpatial(p)
+    f.Mul(ik, a)
+    tmp.Mul(ik, v)
+    tmp.Mul(cf, &tmp)
+    f.Add(&f, &tmp)
+    res.SetCol(k, mat.Col(nil, 0, &f))
+    p[k] = 0
+  }
+  return res
+}
+
+// Dynamic regressor Y for the current tree state: tau = Y*pi,
+// rows are in order of MovableJoints, columns in order of DynLinks,
+// friction is not included
+func (base *Link) Regressor(g float64) *mat.Dense {
+  mov := base.MovableJoints()
+  ind := jointIndex(mov)
+  lnk := base.DynLinks()
+  vel := make(map[*Link]*mat.Dense)
+  acc := make(map[*Link]*mat.Dense)
+  a0 := mat.NewDense(6,1,nil)
+  matInsert(3,0, a0, base.gravity(g))
+  base.motion(mat.NewDense(6,1,nil), a0, vel, acc)
+
+  res := mat.NewDense(len(mov), param_Body*len(lnk), nil)
+  var row, fp mat.Dense
+  for i, v := range lnk {
+    f := forceRegressor(vel[v], acc[v])
+    // move to the root
+    for jnt := v.Parent; jnt != nil; jnt = jnt.Parent.Parent {
+      if j, ok := ind[jnt]; ok {
+        row.Mul(jnt.subspace().T(), f)
+        for k := 0; k < param_Body; k++ {
+          res.Set(j, param_Body*i + k, row.At(0,k))
+        }
+      }
+      fp.Mul(jnt.xform().T(), f)
+      f = mat.DenseCopyOf(&fp)
+    }
+  }
+  return res
+}
+
+// Friction regressor for viscous and Coulomb coefficients of movable joints,
+// columns are [B1, Fc1, B2, Fc2, ...]
+func (base *Link) FrictionRegressor() *mat.Dense {
+  mov := base.MovableJoints()
+  res := mat.NewDense(len(mov), 2*len(mov), nil)
+  for i, jnt := range mov {
+    res.Set(i, 2*i, jnt.Vel)
+    if jnt.Vel > 0 {
+      res.Set(i, 2*i+1, 1)
+    } else if jnt.Vel < 0 {
+      res.Set(i, 2*i+1, -1)
+    }
+  }
+  return res
+}
+
+// Choice of base (identifiable) parameters: pi_b = pi[Indep] + K*pi[Dep]
+type BaseParams struct {
+  Indep  []int        // independent columns of regressor
+  Dep    []int        // dependent columns
+  K      *mat.Dense   // W[Dep] = W[Indep]*K, nil if there are no dependent columns
+}
+
+// Find base parameters from regressor of random states,
+// friction columns are added when fric is true,
+// the states are set for a copy of the tree
+func (src *Link) FindBaseParams(g float64, fric bool) *BaseParams {
+  base := src.GetCopy()
+  mov := base.MovableJoints()
+  cols := param_Body*len(base.DynLinks())
+  if fric {
+    cols += 2*len(mov)
+  }
+  rnd := rand.New(rand.NewSource(1))
+  samples := 2*cols/(len(mov)+1) + 10
+  qs := MakeJointMap(mov)
+  var w *mat.Dense
+  for s := 0; s < samples; s++ {
+    for _, jnt := range mov {
+      lo, up := jnt.Limit[0], jnt.Limit[1]
+      if lo >= up {
+        lo, up = -math.Pi, math.Pi
+      }
+      q := qs[jnt.Src.Name]
+      q[0] = lo + (up-lo)*rnd.Float64()
+      q[1], q[2] = 2*rnd.Float64()-1, 2*rnd.Float64()-1
+    }
+    base.UpdateState(qs)
+    y := base.regressorWith(g, fric)
+    if w == nil {
+      w = y
+    } else {
+      var tmp mat.Dense
+      tmp.Stack(w, y)
+      w = &tmp
+    }
+  }
+  return selectColumns(w)
+}
+
+// Regressor with optional friction columns
+func (base *Link) regressorWith(g float64, fric bool) *mat.Dense {
+  y := base.Regressor(g)
+  if !fric {
+    return y
+  }
+  var res mat.Dense
+  res.Augment(y, base.FrictionRegressor())
+  return &res
+}
+
+// Greedy choice of linearly independent columns
+func selectColumns(w *mat.Dense) *BaseParams {
+  r, c := w.Dims()
+  res := new(BaseParams)
+  var basis [][]float64     // orthonormal vectors
+  for j := 0; j < c; j++ {
+    col := mat.Col(nil, j, w)
+    nrm := mat.Norm(mat.NewVecDense(r, col), 2)
+    if nrm > 1E-9 {
+      // modified Gram-Schmidt
+      for _, b := range basis {
+        d := 0.0
+        for i := range b {
+          d += b[i]*col[i]
+        }
+        for i := range b {
+          col[i] -= d*b[i]
+        }
+      }
+      if rest := mat.Norm(mat.NewVecDense(r, col), 2); rest > 1E-6*nrm {
+        for i := range col {
+          col[i] /= rest
+        }
+        basis = append(basis, col)
+        res.Indep = append(res.Indep, j)
+        continue
+      }
+    }
+    res.Dep = append(res.Dep, j)
+  }
+  if len(res.Dep) > 0 && len(res.Indep) > 0 {
+    res.K = new(mat.Dense)
+    res.K.Solve(selectCols(w, res.Indep), selectCols(w, res.Dep))
+  }
+  return res
+}
+
+// Matrix from the given columns
+func selectCols(w *mat.Dense, cols []int) *mat.Dense {
+  r, _ := w.Dims()
+  res := mat.NewDense(r, len(cols), nil)
+  for j, c := range cols {
+    res.SetCol(j, mat.Col(nil, c, w))
+  }
+  return res
+}
+
+// Base parameters from the full vector
+func (bp *BaseParams) Reduce(pi *mat.Dense) *mat.Dense {
+  res := mat.NewDense(len(bp.Indep), 1, nil)
+  for i, c := range bp.Indep {
+    res.Set(i, 0, pi.At(c,0))
+  }
+  if bp.K != nil {
+    pd := mat.NewDense(len(bp.Dep), 1, nil)
+    for i, c := range bp.Dep {
+      pd.Set(i, 0, pi.At(c,0))
+    }
+    var tmp mat.Dense
+    tmp.Mul(bp.K, pd)
+    res.Add(res, &tmp)
+  }
+  return res
+}
+
+// Regressor for base parameters
+func (bp *BaseParams) Columns(y *mat.Dense) *mat.Dense {
+  return selectCols(y, bp.Indep)
+}
@@ -0,0 +1,187 @@
+package rigid
+
+import (
+  "../urdf"
+  "fmt"
+  "gonum.org/v1/gonum/mat"
+  "strings"
+)
+
+// Separator of robot and link (tool) names in scene frames
+const Scene_Separator = "/"
+
+// Robot in the scene
+type Robot struct {
+  Name  string
+  Base  *Link
+}
+
+// Several robots in the common world frame,
+// frame names are "world", work objects, robot names (base frames)
+// and "robot/link" or "robot/tool" for the current states
+type Scene struct {
+  Robots  []*Robot
+  Work    map[string]*Transform   // work objects w.r.t. world, shared with robots
+}
+
+// Empty scene
+func NewScene() *Scene {
+  return &Scene{Work: make(map[string]*Transform)}
+}
+
+// Find robot with the given name, nil if not found
+func (sc *Scene) Robot(name string) *Link {
+  for _, r := range sc.Robots {
+    if r.Name == name {
+      return r.Base
+    }
+  }
+  return nil
+}
+
+// Check that the name can be used for a new robot or work object
+func (sc *Scene) checkName(name string) error {
+  switch {
+  case name == "" || strings.Contains(name, Scene_Separator):
+    return fmt.Errorf("wrong name '%s'", name)
+  case name == Frame_World || name == Frame_Base:
+    return fmt.Errorf("name %s is reserved", name)
+  case sc.Robot(name) != nil || sc.Work[name] != nil:
+    return fmt.Errorf("name %s is used", name)
+  }
+  return nil
+}
+
+// Add robot with the base position and RPY orientation w.r.t. world,
+// work objects of the scene become available for the robot
+func (sc *Scene) Add(name string, base *Link, xyz, rpy []float64) error {
+  if err := sc.checkName(name); err != nil {
+    return err
+  }
+  base.SetMounting(xyz, rpy)
+  for n, t := range sc.Work {
+    base.frames().Work[n] = t
+  }
+  sc.Robots = append(sc.Robots, &Robot{Name: name, Base: base})
+  return nil
+}
+
+// Add all trees of the model, robots are named by their root links
+// and placed at the world origin
+func (sc *Scene) AddModel(model *urdf.Model) error {
+  trees, err := BodyTrees(model)
+  if err != nil {
+    return err
+  }
+  for _, base := range trees {
+    if err := sc.Add(base.Src.Name, base, []float64{0,0,0}, []float64{0,0,0}); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Add work object w.r.t. world for all robots
+func (sc *Scene) AddWorkObject(name string, xyz, rpy []float64) error {
+  if err := sc.checkName(name); err != nil {
+    return err
+  }
+  t := makeTransform(xyz, rpy)
+  sc.Work[name] = &t
+  for _, r := range sc.Robots {
+    r.Base.frames().Work[name] = &t
+  }
+  return nil
+}
+
+// Inverse transformation
+func (t *Transform) inverse() *Transform {
+  var res Transform
+  res.Reset()
+  res.Rot.Copy(t.Rot.T())
+  res.Pos.Mul(res.Rot, t.Pos)
+  res.Pos.Scale(-1, res.Pos)
+  return &res
+}
+
+// Copy of transformation
+func (t *Transform) copy() *Transform {
+  var res Transform
+  res.Reset()
+  res.Set(t)
+  return &res
+}
+
+// Pose of the named frame w.r.t. world, return false if it is not found
+func (sc *Scene) Pose(frame string) (*Transform, bool) {
+  if frame == Frame_World {
+    res := new(Transform)
+    res.Reset()
+    return res, true
+  }
+  if t, ok := sc.Work[frame]; ok {
+    return t.copy(), true
+  }
+  name, elem := frame, ""
+  if k := strings.Index(frame, Scene_Separator); k >= 0 {
+    name, elem = frame[:k], frame[k+1:]
+  }
+  base := sc.Robot(name)
+  if base == nil {
+    return nil, false
+  }
+  res := base.frames().Base.copy()
+  if elem == "" {
+    return res, true
+  }
+  if lnk := base.Find(elem); lnk != nil {
+    res.Apply(&lnk.State)
+    return res, true
+  }
+  var tool *Tool
+  base.forEach(func (v *Link) {
+    for _, t := range v.Tools {
+      if t.Name == elem {
+        tool = t
+      }
+    }
+  })
+  if tool == nil {
+    return nil, false
+  }
+  res.Apply(&tool.State)
+  return res, true
+}
+
+// Pose of the frame w.r.t. the reference frame,
+// e.g. the tool of one robot in the base of another
+func (sc *Scene) Relative(frame, ref string) (*Transform, bool) {
+  t, ok := sc.Pose(frame)
+  if !ok {
+    return nil, false
+  }
+  r, ok := sc.Pose(ref)
+  if !ok {
+    return nil, false
+  }
+  res := r.inverse()
+  res.Apply(t)
+  return res, true
+}
+
+// Convert pose from any frame of the scene into base frame of the robot
+func (sc *Scene) ToBase(robot, frame string, rot, pos *mat.Dense) (*mat.Dense, *mat.Dense, bool) {
+  if sc.Robot(robot) == nil {
+    return nil, nil, false
+  }
+  t, ok := sc.Relative(frame, robot)
+  if !ok {
+    return nil, nil, false
+  }
+  r := eye33()
+  r.Mul(t.Rot, rot)
+  p := zero31()
+  p.Mul(t.Rot, pos)
+  p.Add(p, t.Pos)
+  return r, p, true
+}
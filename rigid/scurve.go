@@ -0,0 +1,136 @@
+package rigid
+
+import (
+  "math"
+)
+
+// Jerk limited (seven phase) profile: jerk is +J, 0, -J during acceleration,
+// zero for constant velocity and -J, 0, +J during deceleration
+type SCurve struct {
+  D      float64      // total length
+  T      float64      // total period (minimal)
+  V      float64      // reached velocity
+  Time   [8]float64   // phase bounds
+  Jerk   [7]float64
+  state  [7][3]float64   // position, velocity and acceleration at the phase start
+}
+
+// Find S-curve for distance d with start and end velocities v0, v1
+// (in the direction of motion) and limits vmax, amax, jmax,
+// return nil for zero distance or if the end velocity can not be reached
+func NewSCurve(d, v0, v1, vmax, amax, jmax float64) *SCurve {
+  if d == 0 {
+    return nil
+  }
+  // solve for positive distance
+  h := math.Abs(d)
+  if d < 0 {
+    v0, v1 = -v0, -v1
+  }
+  // feasibility
+  tj := math.Min(math.Sqrt(math.Abs(v1 - v0) / jmax), amax / jmax)
+  if tj < amax / jmax {
+    if h < tj*(v0 + v1) {
+      return nil
+    }
+  } else if h < 0.5*(v0 + v1)*(tj + math.Abs(v1 - v0)/amax) {
+    return nil
+  }
+  // phase durations: acceleration, constant velocity, deceleration
+  var tj1, ta, tv, tj2, td float64
+  phase := func(dv, a float64) (float64, float64) {
+    if dv*jmax < a*a {
+      t := math.Sqrt(dv / jmax)
+      return t, 2*t
+    }
+    return a / jmax, a / jmax + dv / a
+  }
+  tj1, ta = phase(vmax - v0, amax)
+  tj2, td = phase(vmax - v1, amax)
+  tv = h / vmax - 0.5*ta*(1 + v0/vmax) - 0.5*td*(1 + v1/vmax)
+  if tv <= 0 {
+    // maximal velocity is not reached
+    tv = 0
+    for a := amax; ; a *= 0.99 {
+      tj1, tj2 = a / jmax, a / jmax
+      delta := math.Pow(a, 4)/(jmax*jmax) + 2*(v0*v0 + v1*v1) + a*(4*h - 2*a/jmax*(v0 + v1))
+      ta = (a*a/jmax - 2*v0 + math.Sqrt(delta)) / (2*a)
+      td = (a*a/jmax - 2*v1 + math.Sqrt(delta)) / (2*a)
+      if ta < 0 {
+        // deceleration only
+        ta, tj1 = 0, 0
+        td = 2*h / (v1 + v0)
+        tj2 = (jmax*h - math.Sqrt(jmax*(jmax*h*h + (v1 + v0)*(v1 + v0)*(v1 - v0)))) / (jmax*(v1 + v0))
+        break
+      }
+      if td < 0 {
+        // acceleration only
+        td, tj2 = 0, 0
+        ta = 2*h / (v1 + v0)
+        tj1 = (jmax*h - math.Sqrt(jmax*(jmax*h*h - (v1 + v0)*(v1 + v0)*(v1 - v0)))) / (jmax*(v1 + v0))
+        break
+      }
+      if (ta >= 2*tj1 && td >= 2*tj2) || a < 1E-6*amax {
+        break
+      }
+    }
+  }
+  p := &SCurve{D: d}
+  dur := [7]float64{tj1, math.Max(0, ta - 2*tj1), tj1, tv, tj2, math.Max(0, td - 2*tj2), tj2}
+  p.Jerk = [7]float64{jmax, 0, -jmax, 0, -jmax, 0, jmax}
+  // integrate phases
+  x, v, a := 0.0, v0, 0.0
+  for i := range dur {
+    p.state[i] = [3]float64{x, v, a}
+    p.Time[i+1] = p.Time[i] + dur[i]
+    t, j := dur[i], p.Jerk[i]
+    x += v*t + a*t*t/2 + j*t*t*t/6
+    v += a*t + j*t*t/2
+    a += j*t
+    p.V = math.Max(p.V, v)
+  }
+  p.T = p.Time[7]
+  if d < 0 {
+    // back to the original direction
+    for i := range p.state {
+      for k := range p.state[i] {
+        p.state[i][k] = -p.state[i][k]
+      }
+      p.Jerk[i] = -p.Jerk[i]
+    }
+    p.V = -p.V
+  }
+  return p
+}
+
+// Calculate state at relative time k (position is relative to the total length),
+// return absolute time
+func (p *SCurve) At(k, period float64, res []float64) float64 {
+  if k < 0 || k > 1 {
+    res[0] = 0
+    res[1] = 0
+    res[2] = 0
+    return k * period
+  }
+  t := k * p.T
+  i := 0
+  for i < 6 && t > p.Time[i+1] {
+    i++
+  }
+  t -= p.Time[i]
+  st, j := &p.state[i], p.Jerk[i]
+  x := st[0] + st[1]*t + st[2]*t*t/2 + j*t*t*t/6
+  v := st[1] + st[2]*t + j*t*t/2
+  a := st[2] + j*t
+  // scale time to the period
+  f := p.T / period
+  res[0] = x / p.D
+  res[1] = v * f / p.D
+  res[2] = a * f * f / p.D
+  return k * period
+}
+
+// Calculate state for relative time and expanded period, return absolute time
+func (p *SCurve) AtScale(k, factor float64, res []float64) float64 {
+  return p.At(k, factor * p.T, res)
+}
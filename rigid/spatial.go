@@ -0,0 +1,89 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+)
+
+// Spatial vectors are 6x1 matrices:
+// motion [w; v] and force [n; f] in link frame
+
+// Skew symmetric matrix for vector product
+func skew(v mat.Matrix) *mat.Dense {
+  x, y, z := v.At(0,0), v.At(1,0), v.At(2,0)
+  return mat.NewDense(3,3, []float64{
+     0, -z,  y,
+     z,  0, -x,
+    -y,  x,  0})
+}
+
+// Motion transformation into frame with rotation rot and origin pos
+// w.r.t. the current one
+func plucker(rot, pos *mat.Dense) *mat.Dense {
+  res := mat.NewDense(6,6,nil)
+  rt := rot.T()
+  matInsert(0,0, res, rt)
+  matInsert(3,3, res, rt)
+  var tmp mat.Dense
+  tmp.Mul(rt, skew(pos))
+  tmp.Scale(-1, &tmp)
+  matInsert(3,0, res, &tmp)
+  return res
+}
+
+// Motion transformation from parent to child link
+func (jnt *Joint) xform() *mat.Dense {
+  return plucker(jnt.Local.Rot, jnt.Local.Pos)
+}
+
+// Motion subspace of joint in child frame
+func (jnt *Joint) subspace() *mat.Dense {
+  res := mat.NewDense(6,1,nil)
+  switch jnt.Type {
+  case joint_Rx, joint_Ry, joint_Rz:
+    matInsert(0,0, res, jnt.Axis)
+  case joint_Tx, joint_Ty, joint_Tz:
+    matInsert(3,0, res, jnt.Axis)
+  }
+  return res
+}
+
+// Spatial inertia w.r.t. link origin
+func (d *Inertial) spatial() *mat.Dense {
+  res := mat.NewDense(6,6,nil)
+  c := skew(d.Rc)
+  var tmp mat.Dense
+  tmp.Mul(c, c.T())
+  tmp.Scale(d.M, &tmp)
+  tmp.Add(&tmp, d.I)
+  matInsert(0,0, res, &tmp)
+  tmp.Scale(d.M, c)
+  matInsert(0,3, res, &tmp)
+  matInsert(3,0, res, tmp.T())
+  matInsert(3,3, res, mat.NewDiagDense(3, []float64{d.M, d.M, d.M}))
+  return res
+}
+
+// Spatial cross product for motion vectors
+func crossMotion(v *mat.Dense) *mat.Dense {
+  res := mat.NewDense(6,6,nil)
+  w := skew(v.Slice(0,3,0,1))
+  matInsert(0,0, res, w)
+  matInsert(3,3, res, w)
+  matInsert(3,0, res, skew(v.Slice(3,6,0,1)))
+  return res
+}
+
+// Spatial cross product for force vectors
+func crossForce(v *mat.Dense) *mat.Dense {
+  res := mat.NewDense(6,6,nil)
+  res.Scale(-1, crossMotion(v).T())
+  return res
+}
+
+// Transform inertia from child to parent frame: X^T I X
+func toParent(x, inertia *mat.Dense) *mat.Dense {
+  var res mat.Dense
+  res.Mul(x.T(), inertia)
+  res.Mul(&res, x)
+  return &res
+}
@@ -0,0 +1,204 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Piecewise polynomial joint trajectory,
+// polynomial Joints[j][i] is a function of the local time t - Time[i]
+type Spline struct {
+  Time    []float64        // segment bounds
+  Joints  [][]Polynomial   // [joint][segment]
+}
+
+// Polynomial of order 2*m-1 on [0, T] with the given m derivatives
+// (position, velocity, acceleration, ...) at the start and end,
+// missing end values are zero
+func Hermite(b0, b1 []float64, T float64) Polynomial {
+  m := len(b0)
+  // coefficients in ascending order for the relative time s = t/T
+  c := make([]float64, 2*m)
+  fact := 1.0
+  for k := 0; k < m; k++ {
+    if k > 0 {
+      fact *= float64(k)
+    }
+    c[k] = b0[k] * math.Pow(T, float64(k)) / fact
+  }
+  // derivatives at s = 1
+  a := mat.NewDense(m, m, nil)
+  rhs := mat.NewDense(m, 1, nil)
+  for d := 0; d < m; d++ {
+    r := 0.0
+    if d < len(b1) {
+      r = b1[d] * math.Pow(T, float64(d))
+    }
+    for k := d; k < 2*m; k++ {
+      // k!/(k-d)!
+      f := 1.0
+      for i := 0; i < d; i++ {
+        f *= float64(k-i)
+      }
+      if k < m {
+        r -= f * c[k]
+      } else {
+        a.Set(d, k-m, f)
+      }
+    }
+    rhs.Set(d, 0, r)
+  }
+  var x mat.Dense
+  if err := x.Solve(a, rhs); err != nil {
+    return nil
+  }
+  for k := 0; k < m; k++ {
+    c[m+k] = x.At(k,0)
+  }
+  res := make(Polynomial, 2*m)
+  for k := range c {
+    res[2*m-1-k] = c[k] / math.Pow(T, float64(k))
+  }
+  return res
+}
+
+// Cubic polynomial on [0, T] with boundary positions and velocities
+func Cubic(q0, v0, q1, v1, T float64) Polynomial {
+  return Hermite([]float64{q0, v0}, []float64{q1, v1}, T)
+}
+
+// Quintic polynomial on [0, T] with boundary positions, velocities and accelerations
+func Quintic(q0, v0, a0, q1, v1, a1, T float64) Polynomial {
+  return Hermite([]float64{q0, v0, a0}, []float64{q1, v1, a1}, T)
+}
+
+// Point to point motion of joints during time T,
+// start[j] and end[j] are [position, velocity, acceleration, ...] of joint j,
+// e.g. positions and velocities give cubic polynomials, with accelerations - quintic
+func PointToPoint(start, end [][]float64, T float64) *Spline {
+  res := &Spline{Time: []float64{0, T}}
+  for j := range start {
+    res.Joints = append(res.Joints, []Polynomial{Hermite(start[j], end[j], T)})
+  }
+  return res
+}
+
+// Cubic spline through points at the given times with continuous acceleration,
+// start and end velocities can be nil (zero)
+func ViaPoints(points [][]float64, times []float64, v0, v1 []float64) *Spline {
+  n := len(points) - 1
+  if n < 1 {
+    return nil
+  }
+  res := &Spline{Time: append([]float64{}, times...)}
+  h := make([]float64, n)
+  for i := range h {
+    h[i] = times[i+1] - times[i]
+  }
+  for j := range points[0] {
+    // velocities in knots
+    v := make([]float64, n+1)
+    if v0 != nil {
+      v[0] = v0[j]
+    }
+    if v1 != nil {
+      v[n] = v1[j]
+    }
+    if n > 1 {
+      // tridiagonal system for internal knots (Thomas algorithm)
+      m := n - 1
+      cp, dp := make([]float64, m), make([]float64, m)
+      for k := 0; k < m; k++ {
+        i := k + 1
+        a, b, c := h[i], 2*(h[i-1] + h[i]), h[i-1]
+        d := 3*(h[i]*(points[i][j] - points[i-1][j])/h[i-1] +
+                h[i-1]*(points[i+1][j] - points[i][j])/h[i])
+        if k == 0 {
+          d -= a * v[0]
+          a = 0
+        }
+        if k == m-1 {
+          d -= c * v[n]
+          c = 0
+        }
+        if k > 0 {
+          b -= a * cp[k-1]
+          d -= a * dp[k-1]
+        }
+        cp[k], dp[k] = c / b, d / b
+      }
+      for k := m-1; k >= 0; k-- {
+        v[k+1] = dp[k]
+        if k < m-1 {
+          v[k+1] -= cp[k] * v[k+2]
+        }
+      }
+    }
+    seg := make([]Polynomial, n)
+    for i := range seg {
+      seg[i] = Cubic(points[i][j], v[i], points[i+1][j], v[i+1], h[i])
+    }
+    res.Joints = append(res.Joints, seg)
+  }
+  return res
+}
+
+// Trajectory duration
+func (s *Spline) Duration() float64 {
+  return s.Time[len(s.Time)-1] - s.Time[0]
+}
+
+// Segment for the time t (limited with the trajectory bounds) and local time
+func (s *Spline) segment(t float64) (int, float64) {
+  n := len(s.Time) - 1
+  t = math.Max(s.Time[0], math.Min(s.Time[n], t))
+  i := 0
+  for i < n-1 && t > s.Time[i+1] {
+    i++
+  }
+  return i, t - s.Time[i]
+}
+
+// Joint positions, velocities and accelerations at time t,
+// the results can be nil
+func (s *Spline) At(t float64, q, qd, qdd []float64) {
+  i, tl := s.segment(t)
+  for j := range s.Joints {
+    p := s.Joints[j][i]
+    if q != nil {
+      q[j] = p.Val(tl)
+    }
+    if qd != nil {
+      qd[j] = p.Val1d(tl)
+    }
+    if qdd != nil {
+      qdd[j] = p.Val2d(tl)
+    }
+  }
+}
+
+// Sample trajectory in n+1 points,
+// return joint path with relative time in S and absolute time stamps
+func (s *Spline) Path(n int) (Path, []float64) {
+  return samplePath(func (t float64, q []float64) {
+    s.At(t, q, nil, nil)
+  }, len(s.Joints), s.Time[0], s.Duration(), n)
+}
+
+// Sample positions of m joints on [t0, t0+dur] in n+1 points,
+// the start point only when n is zero
+func samplePath(at func(float64, []float64), m int, t0, dur float64, n int) (Path, []float64) {
+  var path Path
+  tm := make([]float64, n+1)
+  path.S = make([]float64, n+1)
+  for k := 0; k <= n; k++ {
+    if n > 0 {
+      path.S[k] = float64(k) / float64(n)
+    }
+    tm[k] = t0 + dur * path.S[k]
+    q := make([]float64, m)
+    at(tm[k], q)
+    path.Joints = append(path.Joints, q)
+  }
+  return path, tm
+}
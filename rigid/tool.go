@@ -0,0 +1,166 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+)
+
+// Tool frame attached to link
+type Tool struct {
+  Name    string
+  Link    *Link        // mounting link
+  Frame   Transform    // tool frame w.r.t. the link
+  Dyn     Inertial     // payload, mass center and inertia in the tool frame
+  State   Transform    // current tool pose
+}
+
+// Tool constructor, position and RPY angles w.r.t. the link frame
+func NewTool(name string, xyz, rpy []float64) *Tool {
+  t := new(Tool)
+  t.Name = name
+  t.Frame.Pos = Txyz(xyz[0], xyz[1], xyz[2])
+  t.Frame.Rot = RPY(rpy[0], rpy[1], rpy[2])
+  t.Dyn.Rc = zero31()
+  t.Dyn.I = mat.NewDense(3,3,nil)
+  t.State.Reset()
+  return t
+}
+
+// Attach tool to the link, it is not active
+func (v *Link) AddTool(t *Tool) {
+  t.Link = v
+  v.Tools = append(v.Tools, t)
+  t.update()
+}
+
+// Activate tool with the given name, deactivate the others,
+// return nil if the tool is not found (all tools are deactivated)
+func (base *Link) SetTool(name string) *Tool {
+  var res *Tool
+  base.forEach(func (v *Link) {
+    v.Tool = nil
+    for _, t := range v.Tools {
+      if t.Name == name {
+        v.Tool = t
+        res = t
+      }
+    }
+  })
+  return res
+}
+
+// Find active tool in the tree
+func (base *Link) ActiveTool() *Tool {
+  var res *Tool
+  base.forEach(func (v *Link) {
+    if v.Tool != nil {
+      res = v.Tool
+    }
+  })
+  return res
+}
+
+// Apply function to all links of the tree
+func (v *Link) forEach(fn func(*Link)) {
+  fn(v)
+  for _, jnt := range v.Joints {
+    jnt.Child.forEach(fn)
+  }
+}
+
+// Find tool pose for the current link state
+func (t *Tool) update() {
+  t.State.Set(&t.Link.State)
+  t.State.Apply(&t.Frame)
+}
+
+// Current frame of the end effector:
+// active tool when it is defined, otherwise the link frame
+func (v *Link) Frame() *Transform {
+  if v.Tool != nil {
+    return &v.Tool.State
+  }
+  return &v.State
+}
+
+// Convert desired tool pose into the link pose
+func (t *Tool) ToLink(rot, pos *mat.Dense) (*mat.Dense, *mat.Dense) {
+  r := eye33()
+  r.Mul(rot, t.Frame.Rot.T())
+  p := zero31()
+  p.Mul(r, t.Frame.Pos)
+  p.Sub(pos, p)
+  return r, p
+}
+
+// Mass properties of the payload in the link frame
+func (t *Tool) inertial() *Inertial {
+  var res Inertial
+  res.M = t.Dyn.M
+  res.Rc = zero31()
+  res.Rc.Mul(t.Frame.Rot, t.Dyn.Rc)
+  res.Rc.Add(res.Rc, t.Frame.Pos)
+  res.I = eye33()
+  res.I.Mul(t.Frame.Rot, t.Dyn.I)
+  res.I.Mul(res.I, t.Frame.Rot.T())
+  return &res
+}
+
+// Mass properties of the link with the active tool
+func (v *Link) inertial() *Inertial {
+  if v.Tool == nil || v.Tool.Dyn.M == 0 {
+    return &v.Dyn
+  }
+  res := v.Dyn.Combine(v.Tool.inertial())
+  return &res
+}
+
+// Find mass properties of two bodies in the same frame,
+// inertia w.r.t. the common mass center
+func (a *Inertial) Combine(b *Inertial) Inertial {
+  var res Inertial
+  res.M = a.M + b.M
+  res.Rc = zero31()
+  res.I = mat.NewDense(3,3,nil)
+  if res.M == 0 {
+    return res
+  }
+  var tmp mat.Dense
+  res.Rc.Scale(a.M/res.M, a.Rc)
+  tmp.Scale(b.M/res.M, b.Rc)
+  res.Rc.Add(res.Rc, &tmp)
+  // parallel axis theorem
+  for _, v := range []*Inertial{a, b} {
+    res.I.Add(res.I, v.I)
+    tmp.Sub(v.Rc, res.Rc)
+    res.I.Add(res.I, shiftInertia(v.M, &tmp))
+  }
+  return res
+}
+
+// Inertia of the point mass m at position d
+func shiftInertia(m float64, d *mat.Dense) *mat.Dense {
+  x, y, z := d.At(0,0), d.At(1,0), d.At(2,0)
+  return mat.NewDense(3,3, []float64{
+    m*(y*y+z*z), -m*x*y, -m*x*z,
+    -m*x*y, m*(x*x+z*z), -m*y*z,
+    -m*x*z, -m*y*z, m*(x*x+y*y)})
+}
+
+// Inverse kinematics for the active tool of the link
+type ToolIk struct {
+  IkSolver
+  Link  *Link     // end effector
+}
+
+// Wrap solver to use tool pose as input
+func WithTool(solver IkSolver, ee *Link) *ToolIk {
+  return &ToolIk{IkSolver: solver, Link: ee}
+}
+
+// Inverse kinematics for the desired tool pose
+func (par *ToolIk) IkFull(rot, pos *mat.Dense) {
+  if t := par.Link.Tool; t != nil {
+    rot, pos = t.ToLink(rot, pos)
+  }
+  par.IkSolver.IkFull(rot, pos)
+}
@@ -0,0 +1,250 @@
+package rigid
+
+import (
+  "math"
+)
+
+// Problems found during IK tracking
+type IkEventType int
+const (
+  Ik_Spike IkEventType = iota    // large joint step
+  Ik_WristFlip                   // wrist changes configuration
+  Ik_BranchChange                // tracker moves to another solution
+  Ik_Unreachable                 // no solution for the pose
+)
+
+// Description of the tracking problem
+type IkEvent struct {
+  Type   IkEventType
+  Index  int          // sample index in the path of the Track call
+  Joint  int          // joint with maximal velocity
+  Vel    float64      // joint velocity (joint step when time is not defined)
+}
+
+// Follow sequence of poses with analytical IK
+type IkTracker struct {
+  Solver      IkSolver
+  Joints      []*Joint     // joints in the solver order
+  Branch      int          // current solution column, -1 when not defined
+  KeepBranch  bool         // don't switch to the closest solution
+  MaxStep     float64      // maximal joint step between samples
+  Depth       int          // maximal level of segment subdivision
+  Wrist       int          // index of the first wrist joint
+  Q           []float64    // current joint state
+  Events      []IkEvent
+}
+
+// Tracker constructor, q0 is the initial joint state
+func NewIkTracker(ee *Link, solver IkSolver, q0 []float64) *IkTracker {
+  tr := new(IkTracker)
+  tr.Solver = solver
+  names := solver.Joints()
+  mov := ee.Predecessors()
+  tr.Joints = make([]*Joint, len(names))
+  for i, nm := range names {
+    for _, jnt := range mov {
+      if jnt.Src.Name == nm {
+        tr.Joints[i] = jnt
+      }
+    }
+  }
+  tr.Q = make([]float64, len(names))
+  if q0 != nil {
+    copy(tr.Q, q0)
+  }
+  tr.Branch = -1
+  tr.MaxStep = 0.1
+  tr.Wrist = 3
+  return tr
+}
+
+// Solution from the given column which is the closest to the current state,
+// return nil if it is not found or out of joint limits
+func (tr *IkTracker) candidate(col int) []float64 {
+  sol := tr.Solver.Solutions()
+  res := make([]float64, len(tr.Q))
+  for r := range res {
+    q := sol.At(r, col)
+    if math.IsNaN(q) {
+      return nil
+    }
+    jnt := tr.Joints[r]
+    if jnt.isRevolute() {
+      // unwrap angle
+      q += 2*math.Pi * math.Round((tr.Q[r]-q) / (2*math.Pi))
+    }
+    q, ok := jnt.fitRange(q)
+    if !ok {
+      return nil
+    }
+    res[r] = q
+  }
+  return res
+}
+
+// Maximal joint step, return value and joint index
+func (tr *IkTracker) maxStep(q []float64) (float64, int) {
+  res, ind := 0.0, 0
+  for i := range q {
+    if d := math.Abs(q[i]-tr.Q[i]); d > res {
+      res, ind = d, i
+    }
+  }
+  return res, ind
+}
+
+// Find the next state, don't change the tracker
+func (tr *IkTracker) solve(t *Transform) ([]float64, int, IkEventType, bool) {
+  tr.Solver.IkFull(t.Rot, t.Pos)
+  _, cols := tr.Solver.Solutions().Dims()
+  // closest solution
+  var best []float64
+  bestCol := -1
+  minimal := math.Inf(1)
+  for c := 0; c < cols; c++ {
+    q := tr.candidate(c)
+    if q == nil {
+      continue
+    }
+    diff := 0.0
+    for i := range q {
+      diff += math.Abs(q[i]-tr.Q[i])
+    }
+    if diff < minimal {
+      best, bestCol, minimal = q, c, diff
+    }
+  }
+  if best == nil {
+    return nil, -1, Ik_Unreachable, true
+  }
+  if tr.Branch < 0 || tr.Branch == bestCol {
+    return best, bestCol, Ik_Spike, false
+  }
+  // compare with the current branch
+  if q := tr.candidate(tr.Branch); q != nil && tr.KeepBranch {
+    if step,_ := tr.maxStep(q); step > tr.MaxStep {
+      return q, tr.Branch, Ik_WristFlip, tr.wristOnly(q, best)
+    }
+    return q, tr.Branch, Ik_Spike, false
+  }
+  // switch
+  tp := Ik_BranchChange
+  if q := tr.candidate(tr.Branch); q != nil && tr.wristOnly(q, best) {
+    tp = Ik_WristFlip
+  }
+  return best, bestCol, tp, true
+}
+
+// Check if two states differ only in wrist joints
+func (tr *IkTracker) wristOnly(q1, q2 []float64) bool {
+  if tr.Wrist >= len(q1) {
+    return false
+  }
+  for i := 0; i < tr.Wrist; i++ {
+    if math.Abs(q1[i]-q2[i]) > tr.MaxStep {
+      return false
+    }
+  }
+  return true
+}
+
+// Move to the next pose without subdivision,
+// return new joint state or nil if the pose is not reachable
+func (tr *IkTracker) Next(t *Transform) []float64 {
+  q, col, tp, event := tr.solve(t)
+  if event {
+    tr.Events = append(tr.Events, IkEvent{Type: tp, Index: -1, Joint: -1})
+  }
+  if q == nil {
+    return nil
+  }
+  if step, j := tr.maxStep(q); step > tr.MaxStep && tr.Branch >= 0 {
+    tr.Events = append(tr.Events, IkEvent{Type: Ik_Spike, Index: -1, Joint: j, Vel: step})
+  }
+  copy(tr.Q, q)
+  tr.Branch = col
+  return q
+}
+
+// Follow the sequence of poses, times can be nil,
+// segments with large joint steps are divided into parts
+// Return joint path with relative time in S,
+// the path is empty when the number of times and poses differ
+func (tr *IkTracker) Track(poses []Transform, times []float64) Path {
+  var path Path
+  if len(poses) == 0 || (times != nil && len(times) != len(poses)) {
+    return path
+  }
+  if times == nil {
+    times = make([]float64, len(poses))
+    for i := range times {
+      times[i] = float64(i)
+    }
+  }
+  var tm []float64
+  first := len(tr.Events)
+  tr.add(&path, &tm, &poses[0], times[0])
+  for i := 1; i < len(poses); i++ {
+    tr.segment(&path, &tm, &poses[i-1], &poses[i], times[i-1], times[i], 0)
+  }
+  // normalize time
+  t0, tn := times[0], times[len(times)-1]
+  path.S = make([]float64, len(tm))
+  for i, t := range tm {
+    if tn > t0 {
+      path.S[i] = (t - t0) / (tn - t0)
+    }
+  }
+  // velocities of the events of this call
+  for i := first; i < len(tr.Events); i++ {
+    ev := &tr.Events[i]
+    if ev.Type == Ik_Spike && ev.Index > 0 {
+      dt := tm[ev.Index] - tm[ev.Index-1]
+      if dt > 0 {
+        ev.Vel /= dt
+      }
+    }
+  }
+  return path
+}
+
+// Solve IK for the end of segment, divide it when the step is too large
+func (tr *IkTracker) segment(path *Path, tm *[]float64, p0, p1 *Transform, t0, t1 float64, level int) {
+  if level < tr.Depth && tr.Branch >= 0 {
+    q,_,_,_ := tr.solve(p1)
+    if step,_ := tr.maxStep(q); q == nil || step > tr.MaxStep {
+      pm := Interpolate(p0, p1, 0.5)
+      tm2 := 0.5*(t0 + t1)
+      tr.segment(path, tm, p0, pm, t0, tm2, level+1)
+      tr.segment(path, tm, pm, p1, tm2, t1, level+1)
+      return
+    }
+  }
+  tr.add(path, tm, p1, t1)
+}
+
+// Save the next state into path
+func (tr *IkTracker) add(path *Path, tm *[]float64, p *Transform, t float64) {
+  n := len(tr.Events)
+  q := tr.Next(p)
+  for i := n; i < len(tr.Events); i++ {
+    tr.Events[i].Index = len(path.Joints)
+  }
+  if q == nil {
+    // keep the previous state
+    q = tr.Q
+  }
+  res := make([]float64, len(q))
+  copy(res, q)
+  path.Joints = append(path.Joints, res)
+  *tm = append(*tm, t)
+}
+
+// Convert joint state into map
+func (tr *IkTracker) ToMap(q []float64) map[string][]float64 {
+  qs := MakeJointMap(tr.Joints)
+  for i, jnt := range tr.Joints {
+    qs[jnt.Src.Name][0] = q[i]
+  }
+  return qs
+}
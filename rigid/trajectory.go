@@ -1,8 +1,9 @@
 package rigid
 
 import (
+  "gonum.org/v1/gonum/mat"
   "math"
-//  "gonum.org/v1/gonum/mat"
+  "sort"
 )
 
 // Use arrays as polynomial coefficients
@@ -49,7 +50,6 @@ func (p Polynomial) Val2d (x float64) float64 {
 }
 
 // Derivative
-/*
 func (p Polynomial) Der() Polynomial {
   var res Polynomial 
   if len(p) > 1 {
@@ -63,7 +63,98 @@ func (p Polynomial) Der() Polynomial {
   }
   return res 
 }
-*/
+
+// Integral with value c at x = 0
+func (p Polynomial) Int(c float64) Polynomial {
+  n := len(p)
+  res := make(Polynomial, n+1)
+  for i := 0; i < n; i++ {
+    res[i] = p[i] / float64(n-i)
+  }
+  res[n] = c
+  return res
+}
+
+// Sum of polynomials
+func (p Polynomial) Add(q Polynomial) Polynomial {
+  if len(p) < len(q) {
+    p, q = q, p
+  }
+  res := append(Polynomial{}, p...)
+  d := len(p) - len(q)
+  for i := range q {
+    res[d+i] += q[i]
+  }
+  return res
+}
+
+// Product of polynomials
+func (p Polynomial) Mul(q Polynomial) Polynomial {
+  res := make(Polynomial, len(p)+len(q)-1)
+  for i := range p {
+    for j := range q {
+      res[i+j] += p[i] * q[j]
+    }
+  }
+  return res
+}
+
+// Composition p(q(x))
+func (p Polynomial) Compose(q Polynomial) Polynomial {
+  res := Polynomial{p[0]}
+  for i := 1; i < len(p); i++ {
+    res = res.Mul(q).Add(Polynomial{p[i]})
+  }
+  return res
+}
+
+// Real roots in increasing order (eigenvalues of the companion matrix),
+// nil for a constant
+func (p Polynomial) Roots() []float64 {
+  // skip leading zeros
+  k := 0
+  for k < len(p) && p[k] == 0 {
+    k++
+  }
+  n := len(p) - k - 1
+  if n < 1 {
+    return nil
+  }
+  c := mat.NewDense(n, n, nil)
+  for i := 0; i < n; i++ {
+    c.Set(0, i, -p[k+i+1] / p[k])
+    if i > 0 {
+      c.Set(i, i-1, 1)
+    }
+  }
+  var eig mat.Eigen
+  if !eig.Factorize(c, mat.EigenNone) {
+    return nil
+  }
+  var res []float64
+  d := p.Der()
+  for _, v := range eig.Values(nil) {
+    x := real(v)
+    if math.Abs(imag(v)) > 1E-7*(1 + math.Abs(x)) {
+      continue
+    }
+    // refine with Newton steps while the residual decreases
+    for it := 0; it < 3; it++ {
+      dv := d.Val(x)
+      if dv == 0 {
+        break
+      }
+      xn := x - p.Val(x) / dv
+      if math.Abs(p.Val(xn)) >= math.Abs(p.Val(x)) {
+        break
+      }
+      x = xn
+    }
+    res = append(res, x)
+  }
+  sort.Float64s(res)
+  return res
+}
 
 type Path struct {
   Joints  [][]float64 
@@ -237,14 +328,3 @@ func (p *Profile) At(k, period float64, res []float64) float64 {
 func (p *Profile) AtScale(k, factor float64, res []float64) float64 {
   return p.At(k, factor * p.T, res) 
 }
-
-// function ik (pos, orient, q) (bool) 
-// pos, orient - desirable values 
-// q - current state, return new state here
-// bool - result of evaluation
-
-//func (base *Link) LinRot(qBeg []float64, pEnd, rEnd *mat.Dense, n int, 
-//              func ik(*mat.Dense, *mat.Dense, []float64) bool) Path {
-//  var pt Path 
-//  
-//}
\ No newline at end of file
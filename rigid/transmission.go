@@ -0,0 +1,287 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Motor with gearbox
+type Motor struct {
+  Ratio   float64    // gear ratio, motor velocity / joint velocity
+  Rotor   float64    // rotor inertia on the motor side
+  Eff     float64    // gearbox efficiency, ideal when Eff <= 0 or Eff >= 1
+  Kt      float64    // torque constant, torque / current
+  Power   float64    // maximal mechanical power, 0 if not limited
+}
+
+// Transmission between motors and movable joints,
+// motor angles are theta = G*q, G = diag(Ratio)*K
+type Transmission struct {
+  Joints  []*Joint
+  Motors  []Motor      // motor i drives joint i
+  K       *mat.Dense   // coupling matrix, identity for independent joints
+}
+
+// Create transmission with ideal direct drives for all movable joints
+// and attach it to the tree
+func (base *Link) NewTransmission() *Transmission {
+  tr := new(Transmission)
+  tr.Joints = base.MovableJoints()
+  n := len(tr.Joints)
+  tr.Motors = make([]Motor, n)
+  for i := range tr.Motors {
+    tr.Motors[i] = Motor{Ratio: 1, Eff: 1, Kt: 1}
+  }
+  tr.K = mat.NewDense(n, n, nil)
+  for i := 0; i < n; i++ {
+    tr.K.Set(i, i, 1)
+  }
+  base.Drive = tr
+  return tr
+}
+
+// Index of joint with the given name, -1 if not found
+func (tr *Transmission) index(name string) int {
+  for i, jnt := range tr.Joints {
+    if jnt.Src.Name == name {
+      return i
+    }
+  }
+  return -1
+}
+
+// Set motor for joint with the given name,
+// return false if joint is not found or the gear ratio is zero
+func (tr *Transmission) SetMotor(name string, m Motor) bool {
+  if i := tr.index(name); i >= 0 && m.Ratio != 0 {
+    tr.Motors[i] = m
+    return true
+  }
+  return false
+}
+
+// Motor of the joint 'motor' also depends on the joint 'joint':
+// theta_m = Ratio_m*(q_m + k*q_j), e.g. for coupled wrist axes
+func (tr *Transmission) Couple(motor, joint string, k float64) bool {
+  i, j := tr.index(motor), tr.index(joint)
+  if i < 0 || j < 0 || i == j {
+    return false
+  }
+  tr.K.Set(i, j, k)
+  return true
+}
+
+// Matrix G: theta = G*q
+func (tr *Transmission) G() *mat.Dense {
+  res := mat.DenseCopyOf(tr.K)
+  for i, m := range tr.Motors {
+    for j := range tr.Motors {
+      res.Set(i, j, m.Ratio*res.At(i,j))
+    }
+  }
+  return res
+}
+
+// Check if there are coupled joints
+func (tr *Transmission) coupled() bool {
+  n := len(tr.Joints)
+  for i := 0; i < n; i++ {
+    for j := 0; j < n; j++ {
+      if i != j && tr.K.At(i,j) != 0 {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// Joint space inertia of rotors: G^T*Jm*G
+func (tr *Transmission) Reflected() *mat.Dense {
+  g := tr.G()
+  var jg, res mat.Dense
+  jg.Scale(1, g)
+  for i, m := range tr.Motors {
+    for j := range tr.Motors {
+      jg.Set(i, j, m.Rotor*jg.At(i,j))
+    }
+  }
+  res.Mul(g.T(), &jg)
+  return &res
+}
+
+// Diagonal of reflected inertia
+func (tr *Transmission) armature() map[*Joint]float64 {
+  r := tr.Reflected()
+  res := make(map[*Joint]float64)
+  for i, jnt := range tr.Joints {
+    res[jnt] = r.At(i,i)
+  }
+  return res
+}
+
+// Add reflected inertia to the mass matrix with joint indices ind
+func (tr *Transmission) addInertia(h *mat.Dense, ind map[*Joint]int) {
+  r := tr.Reflected()
+  for i, ji := range tr.Joints {
+    for j, jj := range tr.Joints {
+      a, oka := ind[ji]
+      b, okb := ind[jj]
+      if oka && okb {
+        h.Set(a, b, h.At(a,b) + r.At(i,j))
+      }
+    }
+  }
+}
+
+// Add torques of rotor inertia to the joint torques
+func (base *Link) addRotor() {
+  tr := base.Drive
+  if tr == nil {
+    return
+  }
+  r := tr.Reflected()
+  for i, ji := range tr.Joints {
+    for j, jj := range tr.Joints {
+      ji.Tau += r.At(i,j) * jj.Acc
+    }
+  }
+}
+
+// Take into account off-diagonal terms of the reflected inertia,
+// qdd0 is found for (H + D)*qdd = b where D is the diagonal part:
+// (I + (H + D)^-1*C)*qdd = qdd0, C is the off-diagonal part
+func (tr *Transmission) correctAcc(base *Link, mov []*Joint, qdd0 *mat.Dense) *mat.Dense {
+  n := len(mov)
+  ind := jointIndex(mov)
+  c := mat.NewDense(n, n, nil)
+  tr.addInertia(c, ind)
+  for i := 0; i < n; i++ {
+    c.Set(i, i, 0)
+  }
+  // solve for columns of C without velocities, gravity and external forces
+  vel, trq := make([]float64, n), ReadTorques(mov)
+  for i, jnt := range mov {
+    vel[i] = jnt.Vel
+    jnt.Vel = 0
+  }
+  a := mat.NewDense(n, n, nil)
+  tau := mat.NewDense(n, 1, nil)
+  for j := 0; j < n; j++ {
+    if mat.Norm(c.ColView(j), math.Inf(1)) == 0 {
+      a.Set(j, j, 1)
+      continue
+    }
+    tau.Copy(c.ColView(j))
+    setTorques(mov, tau)
+    col := base.aba(mov, nil, mat.NewDense(6,1,nil))
+    a.SetCol(j, mat.Col(nil, 0, col))
+    a.Set(j, j, a.At(j,j) + 1)
+  }
+  for i, jnt := range mov {
+    jnt.Vel = vel[i]
+  }
+  setTorques(mov, trq)
+  var res mat.Dense
+  if err := res.Solve(a, qdd0); err != nil {
+    return qdd0
+  }
+  for i, jnt := range mov {
+    jnt.Acc = res.At(i,0)
+  }
+  return &res
+}
+
+// Motor angles (velocities, accelerations) for the joint vector
+func (tr *Transmission) ToMotor(q *mat.Dense) *mat.Dense {
+  var res mat.Dense
+  res.Mul(tr.G(), q)
+  return &res
+}
+
+// Joint angles (velocities, accelerations) for the motor vector
+func (tr *Transmission) ToJoint(theta *mat.Dense) *mat.Dense {
+  var res mat.Dense
+  if err := res.Solve(tr.G(), theta); err != nil {
+    return nil
+  }
+  return &res
+}
+
+// Gearbox losses, the motor drives the load when power is positive
+func (m *Motor) efficiency(tau, w float64, toMotor bool) float64 {
+  if m.Eff <= 0 || m.Eff >= 1 {
+    return tau
+  }
+  if (tau*w >= 0) == toMotor {
+    return tau / m.Eff
+  }
+  return tau * m.Eff
+}
+
+// Motor torques for the joint torques (with reflected rotor inertia as from UpdateDyn)
+// and joint velocities and accelerations
+func (tr *Transmission) MotorTorque(tau, qd, qdd *mat.Dense) *mat.Dense {
+  g := tr.G()
+  w, dw := tr.ToMotor(qd), tr.ToMotor(qdd)
+  // load on the gearbox output: G^-T*tau - Jm*dw
+  var res mat.Dense
+  if err := res.Solve(g.T(), tau); err != nil {
+    return nil
+  }
+  for i := range tr.Motors {
+    m := &tr.Motors[i]
+    rot := m.Rotor * dw.At(i,0)
+    res.Set(i, 0, m.efficiency(res.At(i,0) - rot, w.At(i,0), true) + rot)
+  }
+  return &res
+}
+
+// Joint torques for the motor torques and joint velocities and accelerations,
+// the result includes the reflected rotor inertia
+func (tr *Transmission) JointTorque(tm, qd, qdd *mat.Dense) *mat.Dense {
+  w, dw := tr.ToMotor(qd), tr.ToMotor(qdd)
+  a := mat.NewDense(len(tr.Motors), 1, nil)
+  for i := range tr.Motors {
+    m := &tr.Motors[i]
+    rot := m.Rotor * dw.At(i,0)
+    a.Set(i, 0, m.efficiency(tm.At(i,0) - rot, w.At(i,0), false) + rot)
+  }
+  var res mat.Dense
+  res.Mul(tr.G().T(), a)
+  return &res
+}
+
+// Motor currents for the motor torques
+func (tr *Transmission) Current(tm *mat.Dense) *mat.Dense {
+  res := mat.NewDense(len(tr.Motors), 1, nil)
+  for i, m := range tr.Motors {
+    if m.Kt != 0 {
+      res.Set(i, 0, tm.At(i,0) / m.Kt)
+    }
+  }
+  return res
+}
+
+// Motor torques for the currents
+func (tr *Transmission) TorqueFromCurrent(cur *mat.Dense) *mat.Dense {
+  res := mat.NewDense(len(tr.Motors), 1, nil)
+  for i, m := range tr.Motors {
+    res.Set(i, 0, cur.At(i,0) * m.Kt)
+  }
+  return res
+}
+
+// Copy for the tree with the same joint names
+func (tr *Transmission) copyFor(base *Link) *Transmission {
+  res := &Transmission{Motors: append([]Motor{}, tr.Motors...), K: mat.DenseCopyOf(tr.K)}
+  for _, jnt := range tr.Joints {
+    var found *Joint
+    base.forEach(func (v *Link) {
+      if v.Parent != nil && v.Parent.Src == jnt.Src {
+        found = v.Parent
+      }
+    })
+    res.Joints = append(res.Joints, found)
+  }
+  return res
+}
@@ -0,0 +1,93 @@
+package rigid
+
+import (
+  "gonum.org/v1/gonum/mat"
+)
+
+// External force and torque applied to link,
+// torque is w.r.t. the application point
+type Wrench struct {
+  Link    *Link
+  Force   *mat.Dense
+  Torque  *mat.Dense
+  Frame   string       // name of frame (world, base, work object), link frame when empty
+  Point   *mat.Dense   // application point in the frame, link origin when nil
+}
+
+// Spatial force [n; f] in the link frame w.r.t. its origin,
+// return false if the frame is not found
+func (base *Link) wrenchSpatial(w *Wrench) (*mat.Dense, bool) {
+  f, n := zero31(), zero31()
+  if w.Force != nil {
+    f.Copy(w.Force)
+  }
+  if w.Torque != nil {
+    n.Copy(w.Torque)
+  }
+  st := &w.Link.State
+  var r *mat.Dense       // from frame to link
+  p := zero31()          // point w.r.t. link origin in the frame
+  if w.Frame == "" {
+    r = eye33()
+    if w.Point != nil {
+      p.Copy(w.Point)
+    }
+  } else {
+    t, ok := base.frameInBase(w.Frame)
+    if !ok {
+      return nil, false
+    }
+    r = eye33()
+    r.Mul(st.Rot.T(), t.Rot)
+    if w.Point != nil {
+      // point in base frame
+      p.Mul(t.Rot, w.Point)
+      p.Add(p, t.Pos)
+      p.Sub(p, st.Pos)
+      p.Mul(t.Rot.T(), p)
+    }
+  }
+  // torque w.r.t. link origin
+  n.Add(n, Cross(p, f))
+  res := mat.NewDense(6,1,nil)
+  n.Mul(r, n)
+  f.Mul(r, f)
+  matInsert(0,0, res, n)
+  matInsert(3,0, res, f)
+  return res, true
+}
+
+// Collect wrenches for each link,
+// return false if some frame is not found
+func (base *Link) wrenchMap(ext []Wrench) (map[*Link]*mat.Dense, bool) {
+  if len(ext) == 0 {
+    return nil, true
+  }
+  res := make(map[*Link]*mat.Dense)
+  found := true
+  for i := range ext {
+    f, ok := base.wrenchSpatial(&ext[i])
+    if !ok {
+      found = false
+      continue
+    }
+    if prev, ok := res[ext[i].Link]; ok {
+      f.Add(f, prev)
+    }
+    res[ext[i].Link] = f
+  }
+  return res, found
+}
+
+// Static joint torques to exert the force and torque on environment
+// with the end effector (or its active tool): tau = J^T * [F; T],
+// vectors are in base frame, torque w.r.t. the tool point
+func (ee *Link) WrenchTorques(mov []*Joint, force, torque *mat.Dense) *mat.Dense {
+  jac := ee.Jacobian(mov)
+  w := mat.NewDense(6,1,nil)
+  matInsert(0,0, w, force)
+  matInsert(3,0, w, torque)
+  var res mat.Dense
+  res.Mul(jac.T(), w)
+  return &res
+}
@@ -0,0 +1,358 @@
+package sim
+
+import (
+  "../rigid"
+  "gonum.org/v1/gonum/mat"
+  "math"
+)
+
+// Integration method
+type Method int
+const (
+  Method_Rk4 Method = iota    // fixed step Runge-Kutta
+  Method_Euler                // semi-implicit (symplectic) Euler
+  Method_Rk45                 // adaptive Runge-Kutta-Fehlberg
+  Method_Dopri5               // Dormand-Prince, exact time of joint limit impact
+  Method_Lcp                  // time stepping, contacts and limits are complementarity constraints
+)
+
+// Joint torques for the given time and state,
+// all vectors are in order of Sim.Joints
+type Controller func(t float64, q, qd []float64) []float64
+
+// Sample of the time history
+type Record struct {
+  T     float64
+  Q     []float64
+  Qd    []float64
+  Tau   []float64    // torques applied during the last step
+}
+
+// Robot simulator, state is integrated with forward dynamics
+type Sim struct {
+  Base     *rigid.Link
+  Joints   []*rigid.Joint   // movable joints
+  Control  Controller       // use Tau when nil
+  Ext      []rigid.Wrench   // external wrenches
+  G        float64          // gravity
+  Method   Method
+  Dt       float64          // time step, initial step for adaptive method
+  Tol      float64          // relative error for adaptive method
+  MinStep  float64
+  MaxStep  float64
+  Limits   bool             // stop joints at position limits
+  Log      bool             // save history
+  // contacts, spring-damper model for all methods except Method_Lcp
+  Colliders  []rigid.Collider   // link geometry
+  Obstacles  []rigid.Obstacle   // static environment
+  Contact    rigid.ContactParams
+  // current state
+  T        float64
+  Q        []float64
+  Qd       []float64
+  Tau      []float64
+  History  []Record
+  Err      error            // dynamics error, the simulation is stopped
+  qs       map[string][]float64
+}
+
+// Simulator for the tree with initial joint positions q0 (can be nil)
+func New(base *rigid.Link, q0 []float64) *Sim {
+  s := new(Sim)
+  s.Base = base
+  s.Joints = base.MovableJoints()
+  n := len(s.Joints)
+  s.qs = rigid.MakeJointMap(s.Joints)
+  s.Q, s.Qd, s.Tau = make([]float64, n), make([]float64, n), make([]float64, n)
+  if q0 != nil {
+    copy(s.Q, q0)
+  }
+  s.G = 9.81
+  s.Dt, s.Tol = 1E-3, 1E-6
+  s.MinStep, s.MaxStep = 1E-7, 0.01
+  s.Limits, s.Log = true, true
+  s.Contact = rigid.NewContactParams()
+  s.sync()
+  return s
+}
+
+// Write the current state into the tree
+func (s *Sim) sync() {
+  for i, jnt := range s.Joints {
+    v := s.qs[jnt.Src.Name]
+    v[0], v[1] = s.Q[i], s.Qd[i]
+  }
+  s.Base.UpdateState(s.qs)
+}
+
+// Joint accelerations for the state and torques
+func (s *Sim) accel(q, qd []float64, tau *mat.Dense) *mat.Dense {
+  for i, jnt := range s.Joints {
+    v := s.qs[jnt.Src.Name]
+    v[0], v[1] = q[i], qd[i]
+  }
+  ext := s.Ext
+  if s.Method != Method_Lcp && len(s.Obstacles) > 0 {
+    s.Base.UpdateState(s.qs)
+    cnt := s.Base.FindContacts(s.Colliders, s.Obstacles)
+    ext = append(append([]rigid.Wrench{}, s.Ext...), s.Base.ContactWrenches(cnt, &s.Contact)...)
+  }
+  var qdd *mat.Dense
+  var err error
+  if len(s.Base.Loops) > 0 {
+    qdd, _, err = s.Base.LoopForwardDynamics(s.qs, tau, ext, s.G)
+  } else {
+    qdd, err = s.Base.ForwardDynamics(s.qs, tau, ext, s.G)
+  }
+  if err != nil {
+    s.Err = err
+    return mat.NewDense(len(q), 1, nil)
+  }
+  return qdd
+}
+
+// State equation for x = [q; qd] with constant torques
+func (s *Sim) ode(tau *mat.Dense) rigid.Ode {
+  n := len(s.Joints)
+  return func(t float64, x *mat.Dense) *mat.Dense {
+    raw := x.RawMatrix().Data
+    if x.RawMatrix().Stride != 1 {
+      raw = mat.Col(nil, 0, x)
+    }
+    qdd := s.accel(raw[:n], raw[n:], tau)
+    res := mat.NewDense(2*n, 1, nil)
+    for i := 0; i < n; i++ {
+      res.Set(i, 0, raw[n+i])
+      res.Set(n+i, 0, qdd.At(i,0))
+    }
+    return res
+  }
+}
+
+// State vector
+func (s *Sim) state() *mat.Dense {
+  n := len(s.Joints)
+  x := mat.NewDense(2*n, 1, nil)
+  for i := 0; i < n; i++ {
+    x.Set(i, 0, s.Q[i])
+    x.Set(n+i, 0, s.Qd[i])
+  }
+  return x
+}
+
+// Read state vector
+func (s *Sim) setState(x *mat.Dense) {
+  n := len(s.Joints)
+  for i := 0; i < n; i++ {
+    s.Q[i], s.Qd[i] = x.At(i,0), x.At(n+i,0)
+  }
+}
+
+// Stop joints at position limits with impulses after the step h,
+// the impulses are found for the free velocity at the end of the next step
+// of the same length (as in time stepping) and applied to the current velocity
+func (s *Sim) applyLimits(tau *mat.Dense, h float64) {
+  qdd := s.accel(s.Q, s.Qd, tau)
+  s.sync()
+  n := len(s.Joints)
+  v := mat.NewDense(n, 1, nil)
+  for i := 0; i < n; i++ {
+    v.Set(i, 0, s.Qd[i] + h*qdd.At(i,0))
+  }
+  vc := s.impulses(nil, mat.DenseCopyOf(v), h)
+  for i := 0; i < n; i++ {
+    s.Qd[i] += vc.At(i,0) - v.At(i,0)
+  }
+}
+
+// Correct joint velocities v with impulses of contacts and joint limits (when enabled),
+// the tree should be in the current state
+func (s *Sim) impulses(cnt []rigid.Contact, v *mat.Dense, h float64) *mat.Dense {
+  var lim *mat.Dense
+  if s.Limits {
+    lim = v
+  }
+  cs := s.Base.MakeConstraints(cnt, lim, &s.Contact, h)
+  if cs.J == nil {
+    return v
+  }
+  n := len(s.Joints)
+  var chol mat.Cholesky
+  m := s.Base.MassMatrix(s.Joints)
+  if !chol.Factorize(mat.NewSymDense(n, m.RawMatrix().Data)) {
+    return v
+  }
+  var minv mat.SymDense
+  chol.InverseTo(&minv)
+  return cs.Solve(mat.DenseCopyOf(&minv), v, s.Contact.Iter)
+}
+
+// Project the state onto loop constraints to remove the drift
+func (s *Sim) closeLoops() {
+  if len(s.Base.Loops) == 0 {
+    return
+  }
+  s.sync()
+  s.Base.SolveLoops(s.qs, nil)
+  for i, jnt := range s.Joints {
+    v := s.qs[jnt.Src.Name]
+    s.Q[i], s.Qd[i] = v[0], v[1]
+  }
+}
+
+// Save current state
+func (s *Sim) record() {
+  if !s.Log {
+    return
+  }
+  cp := func(v []float64) []float64 { return append([]float64{}, v...) }
+  s.History = append(s.History, Record{T: s.T, Q: cp(s.Q), Qd: cp(s.Qd), Tau: cp(s.Tau)})
+}
+
+// Update torques from controller
+func (s *Sim) torques() *mat.Dense {
+  if s.Control != nil {
+    copy(s.Tau, s.Control(s.T, s.Q, s.Qd))
+  }
+  return mat.NewDense(len(s.Tau), 1, append([]float64{}, s.Tau...))
+}
+
+// Make one integration step not longer than h,
+// torques are constant during the step,
+// return the actual step, the state is not changed after error
+func (s *Sim) advance(h float64) float64 {
+  if s.Err != nil {
+    return 0
+  }
+  if len(s.History) == 0 {
+    s.record()
+  }
+  x, dt := s.state(), s.Dt
+  tau := s.torques()
+  switch s.Method {
+  case Method_Rk4:
+    s.setState(rigid.Rk4Step(s.ode(tau), s.T, s.state(), h))
+  case Method_Euler:
+    qdd := s.accel(s.Q, s.Qd, tau)
+    for i := range s.Q {
+      s.Qd[i] += h * qdd.At(i,0)
+      s.Q[i] += h * s.Qd[i]
+    }
+  case Method_Rk45:
+    h = s.adaptive(tau, math.Min(h, s.MaxStep))
+  case Method_Dopri5:
+    h = s.dopri(tau, h)
+  case Method_Lcp:
+    s.timeStep(tau, h)
+  }
+  s.closeLoops()
+  if s.Limits && s.Method != Method_Lcp && s.Err == nil {
+    s.applyLimits(tau, h)
+  }
+  if s.Err != nil {
+    s.setState(x)
+    s.Dt = dt
+    s.sync()
+    return 0
+  }
+  s.T += h
+  s.sync()
+  s.record()
+  return h
+}
+
+// Adaptive step with error control, update Dt for the next step
+func (s *Sim) adaptive(tau *mat.Dense, h float64) float64 {
+  fn, x := s.ode(tau), s.state()
+  for {
+    xn, e := rigid.Rkf45Step(fn, s.T, x, h)
+    r := e / (s.Tol * (1 + mat.Norm(x, math.Inf(1))))
+    if r <= 1 || h <= s.MinStep {
+      s.setState(xn)
+      // next step
+      k := 4.0
+      if r > 0 {
+        k = math.Min(k, 0.9*math.Pow(r, -0.2))
+      }
+      s.Dt = math.Max(s.MinStep, math.Min(s.MaxStep, h*k))
+      return h
+    }
+    h = math.Max(s.MinStep, h*math.Max(0.1, 0.9*math.Pow(r, -0.25)))
+  }
+}
+
+// Integrate with Dormand-Prince method,
+// stop when joint reaches its limit
+func (s *Sim) dopri(tau *mat.Dense, h float64) float64 {
+  opt := rigid.NewOdeOptions()
+  opt.RelTol, opt.MaxStep = s.Tol, s.MaxStep
+  if s.Limits {
+    opt.Events = s.limitEvents()
+  }
+  sol := rigid.OdeAdaptive(s.ode(tau), s.T, s.state(), s.T + h, opt)
+  s.setState(sol.Last())
+  return sol.T[len(sol.T)-1] - s.T
+}
+
+// Velocity-level time stepping: free motion is corrected with impulses
+// of contacts and joint limits, then positions are updated (semi-implicit Euler)
+func (s *Sim) timeStep(tau *mat.Dense, h float64) {
+  qdd := s.accel(s.Q, s.Qd, tau)
+  n := len(s.Joints)
+  v := mat.NewDense(n, 1, nil)
+  for i := 0; i < n; i++ {
+    v.Set(i, 0, s.Qd[i] + h*qdd.At(i,0))
+  }
+  v = s.impulses(s.Base.FindContacts(s.Colliders, s.Obstacles), v, h)
+  for i := 0; i < n; i++ {
+    s.Qd[i] = v.At(i,0)
+    s.Q[i] += h*s.Qd[i]
+  }
+}
+
+// Events for joints that are not at limits
+func (s *Sim) limitEvents() []rigid.OdeEvent {
+  var res []rigid.OdeEvent
+  for i, jnt := range s.Joints {
+    lo, up := jnt.Limit[0], jnt.Limit[1]
+    if lo >= up {
+      continue
+    }
+    k := i
+    if s.Q[i] > lo {
+      res = append(res, rigid.OdeEvent{
+        Fn: func(t float64, x *mat.Dense) float64 { return x.At(k,0) - lo },
+        Terminal: true, Direction: -1})
+    }
+    if s.Q[i] < up {
+      res = append(res, rigid.OdeEvent{
+        Fn: func(t float64, x *mat.Dense) float64 { return x.At(k,0) - up },
+        Terminal: true, Direction: 1})
+    }
+  }
+  return res
+}
+
+// Total mechanical energy for the current state,
+// without friction and torques it should be constant
+func (s *Sim) Energy() float64 {
+  s.sync()
+  return s.Base.KineticEnergy() + s.Base.PotentialEnergy(s.G)
+}
+
+// Make one step (for co-simulation), return the current time,
+// torques can be set directly when controller is not defined,
+// check Err when the time is not changed
+func (s *Sim) Step() float64 {
+  s.advance(s.Dt)
+  return s.T
+}
+
+// Integrate up to the time tn, the last step is shortened if need,
+// return the history, it stops early on error (see Err)
+func (s *Sim) Run(tn float64) []Record {
+  for tn - s.T > 1E-12 && s.Err == nil {
+    s.advance(math.Min(s.Dt, tn - s.T))
+  }
+  return s.History
+}
@@ -1,9 +1,12 @@
 package urdf 
 
 import (
+    "bytes"
     "encoding/xml"    
+    "io"
     "io/ioutil"
     "os"
+    "regexp"
     "strings"
     "strconv" 
 )
@@ -21,6 +24,7 @@ type Model struct {
   XMLName xml.Name `xml:"robot"`
   Joints []Joint   `xml:"joint"`
   Links  []Link    `xml:"link"`
+  Loops  []Loop    `xml:"loop"`
 }
 
 /* func (m *Model) ParseData() {
@@ -89,6 +93,30 @@ type Child struct {
   Name    string   `xml:"link,attr"` 
 }
 
+// Loop closing joint (extension): frames on two links are connected,
+// revolute joint rotates about the common z axis
+type Loop struct {
+  XMLName xml.Name `xml:"loop"`
+  Type    string   `xml:"type,attr"`       // revolute, spherical or fixed
+  Name    string   `xml:"name,attr"`
+  Parent  LoopLink `xml:"parent"`
+  Child   LoopLink `xml:"child"`
+}
+
+type LoopLink struct {
+  Name    string   `xml:"link,attr"`
+  Xyz     string   `xml:"xyz,attr"`
+  Rpy     string   `xml:"rpy,attr"`
+}
+
+func (v *LoopLink) GetXyz() []float64 {
+  return stringToList(v.Xyz)
+}
+
+func (v *LoopLink) GetRpy() []float64 {
+  return stringToList(v.Rpy)
+}
+
 type Axis_ struct {
   XMLName xml.Name `xml:"axis"`
   Xyz     string   `xml:"xyz,attr"`
@@ -118,6 +146,18 @@ func (v *Joint) GetLimits() (float64,float64) {
   return lo, up
 }
 
+// Maximal joint velocity, 0 when it is not defined
+func (v *Joint) GetVelocity() float64 {
+  vel,_ := strconv.ParseFloat(v.Limit.Velocity,64)
+  return vel
+}
+
+// Maximal joint torque (force), 0 when it is not defined
+func (v *Joint) GetEffort() float64 {
+  eff,_ := strconv.ParseFloat(v.Limit.Effort,64)
+  return eff
+}
+
 /* func (v *Limit_) parseData() {
   v.Effort,_ = strconv.ParseFloat(v.effort,64)
   v.Lower,_ = strconv.ParseFloat(v.lower,64)
@@ -131,6 +171,12 @@ type Dynamics struct {
   Friction string  `xml:"friction,attr"`
 }
 
+func (v *Joint) GetDynamics() (float64,float64) {
+  damp,_ := strconv.ParseFloat(v.Dynamics.Damping,64)
+  fric,_ := strconv.ParseFloat(v.Dynamics.Friction,64)
+  return damp, fric
+}
+
 /* func (v *Dynamics) parseData() {
   v.Damping,_ = strconv.ParseFloat(v.damping,64)
   v.Friction,_ = strconv.ParseFloat(v.friction,64)
@@ -193,6 +239,42 @@ type Collision struct {
 type Geometry struct {
   XMLName xml.Name `xml:"geometry"` 
   Mesh    Mesh     `xml:"mesh"`
+  Box      *Box      `xml:"box"`
+  Cylinder *Cylinder `xml:"cylinder"`
+  Sphere   *Sphere   `xml:"sphere"`
+}
+
+type Box struct {
+  Size    string   `xml:"size,attr"`
+}
+
+type Cylinder struct {
+  Radius  string   `xml:"radius,attr"`
+  Length  string   `xml:"length,attr"`
+}
+
+type Sphere struct {
+  Radius  string   `xml:"radius,attr"`
+}
+
+// Collision primitive: type (box, cylinder, sphere or empty string),
+// its size, origin position and orientation
+func (l *Link) GetCollision() (string, []float64, []float64, []float64) {
+  c := &l.Collision
+  xyz, rpy := stringToList(c.Origin.Xyz), stringToList(c.Origin.Rpy)
+  g := &c.Geometry
+  switch {
+  case g.Box != nil:
+    return "box", stringToList(g.Box.Size), xyz, rpy
+  case g.Cylinder != nil:
+    r,_ := strconv.ParseFloat(g.Cylinder.Radius,64)
+    h,_ := strconv.ParseFloat(g.Cylinder.Length,64)
+    return "cylinder", []float64{r, h}, xyz, rpy
+  case g.Sphere != nil:
+    r,_ := strconv.ParseFloat(g.Sphere.Radius,64)
+    return "sphere", []float64{r}, xyz, rpy
+  }
+  return "", nil, xyz, rpy
 }
 
 type Mesh struct {
@@ -261,3 +343,106 @@ func GetFromFile(fname string) (*Model,error) {
   
   return model, nil  
 }
+
+func formatList(v []float64) string {
+  res := make([]string, len(v))
+  for i := range v {
+    res[i] = strconv.FormatFloat(v[i], 'g', -1, 64)
+  }
+  return strings.Join(res, " ")
+}
+
+// Set mass, mass center and inertia (ixx, ixy, ixz, iyy, iyz, izz)
+func (l *Link) SetInertial(mass float64, rc, inertia []float64) {
+  l.Inertial.Mass.Value = formatList([]float64{mass})
+  l.Inertial.Origin.Xyz = formatList(rc)
+  l.Inertial.Origin.Rpy = "0 0 0"
+  in := &l.Inertial.Inertia
+  in.Ixx, in.Ixy, in.Ixz = formatList(inertia[0:1]), formatList(inertia[1:2]), formatList(inertia[2:3])
+  in.Iyy, in.Iyz, in.Izz = formatList(inertia[3:4]), formatList(inertia[4:5]), formatList(inertia[5:6])
+}
+
+// Copy the source file and replace inertial parameters of links from the model,
+// the rest of the file is not changed
+func (m *Model) UpdateFile(src, dst string) error {
+  data, err := ioutil.ReadFile(src)
+  if err != nil {
+    return err
+  }
+  links := make(map[string]*Link)
+  for i := range m.Links {
+    links[m.Links[i].Name] = &m.Links[i]
+  }
+  var out bytes.Buffer
+  dec := xml.NewDecoder(bytes.NewReader(data))
+  var current *Link      // link with new parameters
+  copied := 0            // position in source
+  start, depth := 0, 0   // replaced element
+  for {
+    off := int(dec.InputOffset())
+    tok, err := dec.RawToken()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return err
+    }
+    switch t := tok.(type) {
+    case xml.StartElement:
+      if depth > 0 {
+        depth++
+      } else if t.Name.Local == "link" {
+        current = nil
+        for _, a := range t.Attr {
+          if a.Name.Local == "name" {
+            current = links[a.Value]
+          }
+        }
+      } else if t.Name.Local == "inertial" && current != nil {
+        start, depth = off, 1
+      }
+    case xml.EndElement:
+      if depth > 0 {
+        depth--
+        if depth == 0 {
+          out.Write(data[copied:start])
+          if err := writeInertial(&out, current, data, start); err != nil {
+            return err
+          }
+          copied = int(dec.InputOffset())
+          current = nil
+        }
+      } else if t.Name.Local == "link" && current != nil {
+        // link without inertial element
+        out.Write(data[copied:off])
+        out.WriteString("  ")
+        if err := writeInertial(&out, current, data, off); err != nil {
+          return err
+        }
+        out.WriteString("\n")
+        copied = off
+        current = nil
+      }
+    }
+  }
+  out.Write(data[copied:])
+  return ioutil.WriteFile(dst, out.Bytes(), 0644)
+}
+
+var emptyElement = regexp.MustCompile(`<(\w+)([^<>]*)></\w+>`)
+
+// Write inertial element with indentation of the line
+func writeInertial(out *bytes.Buffer, l *Link, data []byte, pos int) error {
+  line := bytes.LastIndexByte(data[:pos], '\n') + 1
+  indent := string(data[line:pos])
+  if strings.TrimLeft(indent, "\t ") != "" {
+    indent = ""      // element is not the first in line
+  }
+  res, err := xml.MarshalIndent(l.Inertial, indent, "  ")
+  if err != nil {
+    return err
+  }
+  res = emptyElement.ReplaceAll(res, []byte("<$1$2/>"))
+  out.Write(bytes.TrimPrefix(res, []byte(indent)))
+  return nil
+}